@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mauza/devmetrics/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	planFile      string
+	sandbox       bool
+	sandboxOutput string
+	branch        string
+	baseRef       string
+	notes         bool
+	historyPolicy string
+	backend       string
+	offline       bool
+	noCache       bool
+)
+
+var applyCmd = &cobra.Command{
+	Use:          "apply",
+	Short:        "Apply a commit plan written by generate --plan-out",
+	RunE:         runApply,
+	SilenceUsage: true,
+}
+
+func init() {
+	applyCmd.Flags().StringVar(&planFile, "plan", "", "Path to plan file")
+	applyCmd.MarkFlagRequired("plan")
+	addApplyFlags(applyCmd)
+}
+
+// addApplyFlags registers the flags shared by every command that applies a plan
+func addApplyFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&sandbox, "sandbox", false, "Clone each repository into a scratch directory and generate there")
+	cmd.Flags().StringVar(&sandboxOutput, "sandbox-output", "", "Sandbox result to emit (directory, bundle)")
+	cmd.Flags().StringVar(&branch, "branch", "", "Commit onto this branch instead of the current HEAD, {date} expands to today")
+	cmd.Flags().StringVar(&baseRef, "base-ref", "", "Ref to create the branch from (defaults to HEAD)")
+	cmd.Flags().BoolVar(&notes, "notes", false, "Attach a git note with full provenance to every commit")
+	cmd.Flags().StringVar(&backend, "backend", "", "How commits are built (worktree, objects)")
+	cmd.Flags().StringVar(&historyPolicy, "history-policy", "", "What to do when the schedule starts before the base commit (shift, orphan, refuse)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "Generate changes locally from templates instead of calling the LLM")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Ask the LLM again instead of reusing responses cached by earlier runs")
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	config, err := internal.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	plan, err := internal.LoadPlan(planFile)
+	if err != nil {
+		return err
+	}
+
+	return applyPlan(cmd.Context(), config, plan)
+}
+
+// applyPlan executes every planned commit using the configured LLM
+func applyPlan(ctx context.Context, config *internal.Config, plan *internal.Plan) error {
+	if err := applyFlags(config); err != nil {
+		return err
+	}
+	for i := range plan.Repositories {
+		repoPlan := &plan.Repositories[i]
+		if backend != "" {
+			repoPlan.Backend = backend
+		}
+		if branch != "" {
+			repoPlan.Branch = internal.ExpandBranchName(branch, time.Now())
+		}
+		if baseRef != "" {
+			repoPlan.BaseRef = baseRef
+		}
+	}
+
+	runner, err := newPlanRunner(config, internal.NewRunLedger(plan.Persona), plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Starting run %s\n", runner.ledger.ID)
+
+	return runner.run(ctx)
+}
+
+// resumeRun continues an interrupted run from the checkpoint of each of its
+// repositories, using the plan saved when the run started
+func resumeRun(ctx context.Context, config *internal.Config, runID string) error {
+	if err := applyFlags(config); err != nil {
+		return err
+	}
+
+	ledger, err := internal.LoadLedger(config.StateDir, runID)
+	if err != nil {
+		return err
+	}
+	if status := ledger.Status(); status != "incomplete" {
+		return fmt.Errorf("run %s is %s and cannot be resumed", runID, status)
+	}
+
+	plan, err := internal.LoadPlan(internal.RunFile(config.StateDir, runID, "plan.json"))
+	if err != nil {
+		return err
+	}
+
+	runner, err := newPlanRunner(config, ledger, plan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Resuming run %s\n", runID)
+
+	return runner.run(ctx)
+}
+
+// repositoryFlags applies the command line overrides of the backend, branch
+// and base ref to a configured repository
+func repositoryFlags(repo internal.Repository) internal.Repository {
+	if backend != "" {
+		repo.Backend = backend
+	}
+	if branch != "" {
+		repo.Branch = branch
+	}
+	if baseRef != "" {
+		repo.BaseRef = baseRef
+	}
+	return repo
+}
+
+// applyFlags applies the command line overrides to the config and validates
+// the settings used when applying a plan
+func applyFlags(config *internal.Config) error {
+	if sandbox {
+		config.Sandbox.Enabled = true
+	}
+	if sandboxOutput != "" {
+		config.Sandbox.Output = sandboxOutput
+	}
+	if notes {
+		config.Provenance.Notes = true
+	}
+	if historyPolicy != "" {
+		config.HistoryPolicy = historyPolicy
+	}
+	if offline {
+		config.LLM.Provider = internal.ProviderOffline
+	}
+	if noCache {
+		config.Cache.Disabled = true
+	}
+	switch config.HistoryPolicy {
+	case internal.HistoryRefuse, internal.HistoryOrphan, internal.HistoryShift:
+	default:
+		return fmt.Errorf("invalid history policy %q", config.HistoryPolicy)
+	}
+	if err := internal.ValidateBackend(backend); err != nil {
+		return err
+	}
+	if config.Sandbox.Output != internal.SandboxOutputDirectory && config.Sandbox.Output != internal.SandboxOutputBundle {
+		return fmt.Errorf("invalid sandbox output %q", config.Sandbox.Output)
+	}
+	return nil
+}
+
+// emitSandbox reports where the sandboxed result of a repository lives,
+// converting the clone into a bundle when requested
+func emitSandbox(cfg internal.SandboxConfig, sourcePath, sandboxPath string) error {
+	if cfg.Output != internal.SandboxOutputBundle {
+		fmt.Printf("Sandbox for %s: %s\n", sourcePath, sandboxPath)
+		return nil
+	}
+
+	bundlePath := internal.BundlePath(sandboxPath)
+	if err := internal.WriteBundle(sandboxPath, bundlePath); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(sandboxPath); err != nil {
+		return fmt.Errorf("failed to remove sandbox clone: %w", err)
+	}
+
+	fmt.Printf("Sandbox bundle for %s: %s\n", sourcePath, bundlePath)
+	return nil
+}
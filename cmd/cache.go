@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mauza/devmetrics/internal"
+	"github.com/spf13/cobra"
+)
+
+var cacheOlderThan time.Duration
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and clear the LLM response cache",
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show what the LLM response cache holds",
+	RunE:  runCacheStats,
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove cached LLM responses",
+	RunE:  runCacheClear,
+}
+
+func init() {
+	cacheClearCmd.Flags().DurationVar(&cacheOlderThan, "older-than", 0, "Only remove responses cached longer ago than this, e.g. 168h")
+
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
+
+func runCacheStats(cmd *cobra.Command, args []string) error {
+	config, err := internal.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	stats, err := internal.OpenResponseStore(config.Cache.Dir).Stats()
+	if err != nil {
+		return err
+	}
+
+	if stats.Entries == 0 {
+		fmt.Printf("No responses cached in %s\n", config.Cache.Dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Directory:\t%s\n", config.Cache.Dir)
+	fmt.Fprintf(w, "Entries:\t%d\n", stats.Entries)
+	fmt.Fprintf(w, "Size:\t%.1f KB\n", float64(stats.Bytes)/1024)
+	fmt.Fprintf(w, "Oldest:\t%s\n", stats.Oldest.Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "Newest:\t%s\n", stats.Newest.Format("2006-01-02 15:04"))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tENTRIES")
+	for _, model := range sortedKeys(stats.ByModel) {
+		fmt.Fprintf(w, "%s\t%d\n", model, stats.ByModel[model])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	byTask := make(map[string]int)
+	for task, n := range stats.ByTask {
+		byTask[string(task)] = n
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tENTRIES")
+	for _, task := range sortedKeys(byTask) {
+		fmt.Fprintf(w, "%s\t%d\n", task, byTask[task])
+	}
+	return w.Flush()
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) error {
+	config, err := internal.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	removed, err := internal.OpenResponseStore(config.Cache.Dir).Clear(cacheOlderThan)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %d cached responses\n", removed)
+	return nil
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,8 +2,6 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/mauza/devmetrics/internal"
@@ -15,18 +13,29 @@ var (
 	repoPath string
 	days     int
 	persona  string
+	planOut  string
+	resume   string
+
+	featureBranches bool
+	releases        bool
 )
 
 var generateCmd = &cobra.Command{
-	Use:   "generate",
-	Short: "Generate git commits with realistic changes",
-	RunE:  runGenerate,
+	Use:          "generate",
+	Short:        "Generate git commits with realistic changes",
+	RunE:         runGenerate,
+	SilenceUsage: true,
 }
 
 func init() {
 	generateCmd.Flags().StringVar(&repoPath, "repo-path", "", "Path to repository (overrides config file)")
 	generateCmd.Flags().IntVar(&days, "days", 7, "Number of days to generate commits for")
 	generateCmd.Flags().StringVar(&persona, "persona", "", "Developer persona to use (early_bird, night_owl, balanced)")
+	generateCmd.Flags().StringVar(&planOut, "plan-out", "", "Write the commit plan to this file instead of applying it")
+	generateCmd.Flags().StringVar(&resume, "resume", "", "Continue an interrupted run from its last checkpoint")
+	generateCmd.Flags().BoolVar(&featureBranches, "feature-branches", false, "Put feature work on topic branches that are merged back")
+	generateCmd.Flags().BoolVar(&releases, "releases", false, "Tag releases at the end of release and hotfix phases")
+	addApplyFlags(generateCmd)
 }
 
 func runGenerate(cmd *cobra.Command, args []string) error {
@@ -36,27 +45,38 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Get API key from environment variable
-	apiKey := os.Getenv(config.LLM.APIKeyEnvVar)
-	if apiKey == "" {
-		return fmt.Errorf("API key environment variable %s is not set", config.LLM.APIKeyEnvVar)
+	if resume != "" {
+		return resumeRun(cmd.Context(), config, resume)
 	}
 
-	// Initialize components
-	llm, err := internal.NewLLMOperations(
-		config.LLM.Provider,
-		config.LLM.Endpoint,
-		apiKey,
-		config.LLM.Model,
-		config.LLM.Temperature,
-		config.LLM.MaxTokens,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to initialize LLM: %w", err)
+	if err := internal.ValidateBackend(backend); err != nil {
+		return err
+	}
+	plan := buildPlan(config)
+
+	if planOut != "" {
+		if err := internal.SavePlan(plan, planOut); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote plan with %d repositories to %s\n", len(plan.Repositories), planOut)
+		return nil
 	}
-	defer llm.Close()
 
+	return applyPlan(cmd.Context(), config, plan)
+}
+
+// buildPlan picks commit patterns and the files each commit will touch
+// without modifying any repository
+func buildPlan(config *internal.Config) *internal.Plan {
 	patternGen := internal.NewCommitPatternGenerator()
+	if featureBranches {
+		config.Workflow.FeatureBranches = true
+	}
+	patternGen.SetWorkflow(config.Workflow)
+	if releases {
+		config.Releases.Enabled = true
+	}
+	patternGen.SetReleases(config.Releases.Enabled)
 
 	// Generate commit patterns
 	endDate := time.Now()
@@ -75,87 +95,74 @@ func runGenerate(cmd *cobra.Command, args []string) error {
 		repositories = config.Repositories
 	}
 
-	// Process each repository
+	plan := &internal.Plan{CreatedAt: time.Now()}
+	if len(patterns) > 0 {
+		plan.Persona = patterns[0].Persona
+	}
+
 	for _, repo := range repositories {
+		repo = repositoryFlags(repo)
+		branchName := internal.ExpandBranchName(repo.Branch, plan.CreatedAt)
+
 		gitOps, err := internal.NewGitOperations(repo.Path)
 		if err != nil {
 			fmt.Printf("Skipping repository due to error: %v\n", err)
 			continue
 		}
 
-		// Verify repository access
-		if err := gitOps.VerifyRepoAccess(); err != nil {
-			fmt.Printf("Skipping repository due to access issues: %v\n", err)
-			continue
-		}
-
-		fmt.Printf("Generating commits for %s\n", repo.Path)
-
-		// Process each commit pattern
-		for _, pattern := range patterns {
-			// Get list of files we can modify
-			modifiableFiles, err := gitOps.GetModifiableFiles(repo.Patterns)
-			if err != nil {
-				fmt.Printf("Error getting modifiable files: %v\n", err)
-				continue
-			}
-
-			if len(modifiableFiles) == 0 {
-				fmt.Printf("No matching files found in %s\n", repo.Path)
+		if repo.Backend == internal.BackendObjects {
+			if err := gitOps.UseObjectBackend(); err != nil {
+				fmt.Printf("Skipping repository due to error: %v\n", err)
 				continue
 			}
-
-			// Select files to modify
-			numFiles := min(pattern.NumFiles, len(modifiableFiles))
-			filesToModify := selectRandomFiles(modifiableFiles, numFiles)
-
-			var changesDescription []string
-
-			// Modify each file
-			for _, filePath := range filesToModify {
-				content, err := gitOps.ReadFile(filePath)
-				if err != nil {
+			// Plan against the tree the commits will be built on, which the
+			// object backend can read without touching the worktree
+			if branchName != "" {
+				if _, err := gitOps.CheckoutBranch(branchName, repo.BaseRef); err != nil {
+					fmt.Printf("Skipping repository due to error: %v\n", err)
 					continue
 				}
-
-				// Generate changes using LLM
-				newContent, changeDesc, err := llm.GenerateCodeChanges(filePath, content)
-				if err != nil {
-					continue
-				}
-
-				// Apply changes
-				if err := gitOps.ModifyFile(filePath, newContent); err != nil {
-					continue
-				}
-
-				changesDescription = append(changesDescription,
-					fmt.Sprintf("%s: %s", filepath.Base(filePath), changeDesc))
 			}
+		} else if gitOps.IsBare() {
+			fmt.Printf("Skipping bare repository %s, it requires the %s backend\n", repo.Path, internal.BackendObjects)
+			continue
+		}
+
+		// Get list of files we can modify
+		modifiableFiles, err := gitOps.GetModifiableFiles(repo)
+		if err != nil {
+			fmt.Printf("Error getting modifiable files: %v\n", err)
+			continue
+		}
 
-			if len(changesDescription) > 0 {
-				// Generate commit message
-				changesSummary := fmt.Sprintf("%s\n\nChanges:\n%s",
-					pattern.Description,
-					formatChanges(changesDescription))
+		if len(modifiableFiles) == 0 {
+			fmt.Printf("No matching files found in %s\n", repo.Path)
+			continue
+		}
 
-				commitMsg, err := llm.GenerateCommitMessage(changesSummary)
-				if err != nil {
-					fmt.Printf("Error generating commit message: %v\n", err)
-					continue
-				}
+		repoPlan := internal.RepositoryPlan{
+			Path:    repo.Path,
+			Branch:  branchName,
+			BaseRef: repo.BaseRef,
+			Backend: repo.Backend,
+		}
+		files := internal.NewFilePlanner(repo, modifiableFiles)
+		for _, pattern := range patterns {
+			planned := files.Plan(pattern)
 
-				// Create commit with pattern timestamp
-				if err := gitOps.CreateCommit(commitMsg, filesToModify, &pattern.Timestamp); err != nil {
-					fmt.Printf("Failed to create commit: %v\n", err)
-				} else {
-					fmt.Printf("Created commit: %s\n", commitMsg)
-				}
+			// Select files to modify alongside any files added, deleted or renamed
+			available := files.Available(planned)
+			numFiles := min(pattern.NumFiles-len(planned.Paths()), len(available))
+			if numFiles > 0 {
+				planned.Files = selectRandomFiles(available, numFiles)
 			}
+			repoPlan.Commits = append(repoPlan.Commits, planned)
 		}
+
+		plan.Repositories = append(plan.Repositories, repoPlan)
 	}
 
-	return nil
+	return plan
 }
 
 func min(a, b int) int {
@@ -177,11 +184,3 @@ func selectRandomFiles(files []string, n int) []string {
 
 	return result[:n]
 }
-
-func formatChanges(changes []string) string {
-	var result string
-	for _, change := range changes {
-		result += fmt.Sprintf("- %s\n", change)
-	}
-	return result
-}
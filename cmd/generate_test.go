@@ -0,0 +1,271 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mauza/devmetrics/internal"
+)
+
+// baseTime dates the initial commit of test repositories, well before any
+// generated schedule
+var baseTime = time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)
+
+// generatedCommit is what a test compares of a commit made by a run
+type generatedCommit struct {
+	Tree    string
+	When    string
+	Author  string
+	Subject string
+}
+
+// newTestRepository creates a repository holding a few Go files in one
+// initial commit. Repositories created this way are identical.
+func newTestRepository(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct{ name, pkg string }{
+		{"main.go", "main"},
+		{"server/server.go", "server"},
+		{"store/store.go", "store"},
+	}
+	for i, file := range files {
+		name := file.name
+		content := fmt.Sprintf(`package %s
+
+import "fmt"
+
+// Describe returns a short description of item %d
+func Describe(name string) string {
+	if name == "" {
+		return "unnamed"
+	}
+	return fmt.Sprintf("item %d: %%s", name)
+}
+
+// Count adds up the lengths of names
+func Count(names []string) int {
+	total := 0
+	for _, name := range names {
+		total += len(name)
+	}
+	return total
+}
+`, file.pkg, i, i)
+		full := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signature := &object.Signature{Name: "Maintainer", Email: "maintainer@example.com", When: baseTime}
+	if _, err := worktree.Commit("Initial commit", &git.CommitOptions{Author: signature, Committer: signature}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newTestConfig configures the offline provider for the given repositories
+func newTestConfig(t *testing.T, paths ...string) *internal.Config {
+	t.Helper()
+
+	var repositories strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&repositories, "  - path: %q\n    patterns: [\"*.go\"]\n", path)
+	}
+	data := fmt.Sprintf("llm:\n  provider: offline\n  seed: 42\nstate_dir: %q\nrepositories:\n%s", t.TempDir(), repositories.String())
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := internal.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+// planFor builds a plan over the last week, saves it and loads it back the
+// way generate --plan-out followed by apply does
+func planFor(t *testing.T, config *internal.Config) *internal.Plan {
+	t.Helper()
+
+	savedDays, savedRepoPath, savedPersona := days, repoPath, persona
+	t.Cleanup(func() { days, repoPath, persona = savedDays, savedRepoPath, savedPersona })
+	days, repoPath, persona = 7, "", "early_bird"
+
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+	if err := internal.SavePlan(buildPlan(config), planPath); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := internal.LoadPlan(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Repositories) != len(config.Repositories) {
+		t.Fatalf("plan has %d repositories, want %d", len(plan.Repositories), len(config.Repositories))
+	}
+	return plan
+}
+
+// generatedCommits lists the commits on rev made after the initial commit,
+// oldest first
+func generatedCommits(t *testing.T, path, rev string) []generatedCommit {
+	t.Helper()
+
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commits []generatedCommit
+	for commit.NumParents() > 0 {
+		commits = append([]generatedCommit{{
+			Tree:    commit.TreeHash.String(),
+			When:    commit.Author.When.UTC().Format(time.RFC3339),
+			Author:  fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email),
+			Subject: internal.Subject(commit.Message),
+		}}, commits...)
+		if commit, err = commit.Parent(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return commits
+}
+
+func TestGenerateOffline(t *testing.T) {
+	path := newTestRepository(t)
+	config := newTestConfig(t, path)
+	plan := planFor(t, config)
+	planned := plan.Repositories[0].Commits
+	if len(planned) == 0 {
+		t.Fatal("plan has no commits")
+	}
+
+	if err := applyPlan(context.Background(), config, plan); err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+
+	commits := generatedCommits(t, path, "HEAD")
+	if len(commits) != len(planned) {
+		t.Fatalf("got %d commits, want %d", len(commits), len(planned))
+	}
+	for i, commit := range commits {
+		if want := planned[i].Pattern.Timestamp.UTC().Format(time.RFC3339); commit.When != want {
+			t.Errorf("commit %d dated %s, want %s", i, commit.When, want)
+		}
+		if commit.Author != "Dev Metrics <dev@metrics.local>" {
+			t.Errorf("commit %d authored by %s", i, commit.Author)
+		}
+	}
+
+	ledgers, err := internal.ListLedgers(config.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledgers) != 1 || ledgers[0].NumCommits() != len(planned) || ledgers[0].Status() != "finished" {
+		t.Errorf("ledgers = %+v, want one complete run with %d commits", ledgers, len(planned))
+	}
+
+	gitOps, err := internal.OpenRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean, err := gitOps.IsClean(); err != nil || !clean {
+		t.Errorf("worktree clean = %v, %v after the run", clean, err)
+	}
+}
+
+func TestGenerateOfflineSameSeed(t *testing.T) {
+	first, second := newTestRepository(t), newTestRepository(t)
+	config := newTestConfig(t, first, second)
+
+	// Both repositories get the same schedule and files, so the commits can
+	// only differ if the offline provider does
+	plan := planFor(t, config)
+	plan.Repositories[1].Commits = plan.Repositories[0].Commits
+
+	if err := applyPlan(context.Background(), config, plan); err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+
+	want := generatedCommits(t, first, "HEAD")
+	got := generatedCommits(t, second, "HEAD")
+	if len(got) != len(want) {
+		t.Fatalf("got %d commits, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("commit %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestGenerateOfflineBareRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bare.git")
+	if _, err := git.PlainClone(path, true, &git.CloneOptions{URL: newTestRepository(t)}); err != nil {
+		t.Fatal(err)
+	}
+	config := newTestConfig(t, path)
+
+	// The flags alone select the object backend, as with
+	// generate --backend objects --branch demo
+	savedBackend, savedBranch := backend, branch
+	t.Cleanup(func() { backend, branch = savedBackend, savedBranch })
+	backend, branch = internal.BackendObjects, "demo"
+
+	plan := planFor(t, config)
+	planned := plan.Repositories[0].Commits
+	if len(planned) == 0 {
+		t.Fatal("plan has no commits")
+	}
+	if err := applyPlan(context.Background(), config, plan); err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+
+	if commits := generatedCommits(t, path, "HEAD"); len(commits) != 0 {
+		t.Errorf("HEAD moved by %d commits, want it left alone", len(commits))
+	}
+	commits := generatedCommits(t, path, "refs/heads/demo")
+	if len(commits) != len(planned) {
+		t.Fatalf("got %d commits on demo, want %d", len(commits), len(planned))
+	}
+	for i, commit := range commits {
+		if want := planned[i].Pattern.Timestamp.UTC().Format(time.RFC3339); commit.When != want {
+			t.Errorf("commit %d dated %s, want %s", i, commit.When, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mauza/devmetrics/internal"
+	"github.com/spf13/cobra"
+)
+
+var forceRevert bool
+
+var revertCmd = &cobra.Command{
+	Use:   "revert <run-id>",
+	Short: "Restore every branch touched by a run to its pre-run commit",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRevert,
+}
+
+func init() {
+	revertCmd.Flags().BoolVar(&forceRevert, "force", false, "Revert even if branches have moved since the run")
+}
+
+func runRevert(cmd *cobra.Command, args []string) error {
+	config, err := internal.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	ledger, err := internal.LoadLedger(config.StateDir, args[0])
+	if err != nil {
+		return err
+	}
+
+	if ledger.RevertedAt != nil {
+		return fmt.Errorf("run %s was already reverted at %s", ledger.ID, ledger.RevertedAt.Format(time.RFC3339))
+	}
+
+	failed := 0
+	for _, repo := range ledger.Repositories {
+		if err := revertRepository(repo, config.Provenance.NotesRef); err != nil {
+			fmt.Printf("Failed to revert %s: %v\n", repo.Path, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to revert %d of %d repositories", failed, len(ledger.Repositories))
+	}
+
+	now := time.Now()
+	ledger.RevertedAt = &now
+	if err := internal.SaveLedger(config.StateDir, ledger); err != nil {
+		return err
+	}
+
+	fmt.Printf("Reverted run %s\n", ledger.ID)
+	return nil
+}
+
+// revertRepository restores the branch a run committed onto and drops the
+// notes of the commits it removes
+func revertRepository(repo *internal.RepositoryRun, notesRef string) error {
+	if repo.Sandbox {
+		fmt.Printf("Skipping %s: run was generated in a sandbox\n", repo.Path)
+		return nil
+	}
+
+	if len(repo.Commits) == 0 && !repo.CreatedBranch && len(repo.Tags) == 0 {
+		fmt.Printf("Skipping %s: run created no commits\n", repo.Path)
+		return nil
+	}
+
+	gitOps, err := internal.NewGitOperations(repo.Path)
+	if err != nil {
+		return err
+	}
+
+	restoreBranch := true
+	if repo.CreatedBranch && !gitOps.HasBranch(repo.Branch) {
+		fmt.Printf("Skipping %s: branch %s no longer exists\n", repo.Path, repo.Branch)
+		restoreBranch = false
+	} else if last := repo.LastCommit(); last != "" {
+		tip, err := gitOps.BranchTip(repo.Branch)
+		if err != nil {
+			return err
+		}
+		if tip.String() == repo.BaseCommit {
+			fmt.Printf("Skipping %s: %s is already at its pre-run commit\n", repo.Path, displayBranch(repo.Branch))
+			restoreBranch = false
+		} else if tip.String() != last && !forceRevert {
+			return fmt.Errorf("branch has moved to %s since the run (expected %s), use --force to revert anyway", tip, last)
+		}
+	}
+
+	current, _, err := gitOps.Head()
+	if err != nil {
+		return err
+	}
+
+	// An orphan branch has no pre-run commit to go back to, so it can only
+	// be deleted
+	if restoreBranch && repo.BaseCommit == "" && repo.Branch == current {
+		return fmt.Errorf("orphan branch %s is checked out, check out another branch to delete it", repo.Branch)
+	}
+
+	for _, tag := range repo.Tags {
+		if !gitOps.HasTag(tag) {
+			continue
+		}
+		if err := gitOps.DeleteTag(tag); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted tag %s in %s\n", tag, repo.Path)
+	}
+
+	for _, created := range append(repo.TopicBranches, repo.ReleaseBranches...) {
+		if !gitOps.HasBranch(created) {
+			continue
+		}
+		if err := gitOps.DeleteBranch(created); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted branch %s in %s\n", created, repo.Path)
+	}
+
+	var commits []plumbing.Hash
+	for _, commit := range repo.Commits {
+		commits = append(commits, plumbing.NewHash(commit.Hash))
+	}
+	removed, err := gitOps.RemoveNotes(notesRef, commits)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		fmt.Printf("Removed %d notes from %s in %s\n", removed, notesRef, repo.Path)
+	}
+
+	if !restoreBranch {
+		return nil
+	}
+
+	if repo.BaseCommit == "" || repo.CreatedBranch && repo.Branch != current {
+		if err := gitOps.DeleteBranch(repo.Branch); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted branch %s in %s\n", repo.Branch, repo.Path)
+		return nil
+	}
+
+	if err := gitOps.ResetBranch(repo.Branch, plumbing.NewHash(repo.BaseCommit)); err != nil {
+		return err
+	}
+	fmt.Printf("Reset %s in %s to %s\n", displayBranch(repo.Branch), repo.Path, repo.BaseCommit)
+	return nil
+}
+
+func displayBranch(branch string) string {
+	if branch == "" {
+		return "HEAD"
+	}
+	return branch
+}
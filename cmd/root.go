@@ -1,6 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/spf13/cobra"
 )
 
@@ -15,11 +21,41 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "config.yaml", "Path to config file")
 
 	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(runsCmd)
+	rootCmd.AddCommand(revertCmd)
 	rootCmd.AddCommand(validateCmd)
 	rootCmd.AddCommand(setupCmd)
+	rootCmd.AddCommand(cacheCmd)
 }
 
-// Execute executes the root command
+// Execute executes the root command with a context that is cancelled on
+// Ctrl-C or SIGTERM
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := interruptContext()
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// interruptContext returns a context cancelled by the first Ctrl-C or
+// SIGTERM. Signals stay caught until stop is called, so repeated Ctrl-C never
+// kills a run halfway through a commit.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			fmt.Printf("Interrupted, stopping once the current step is done\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
 }
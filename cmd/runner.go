@@ -0,0 +1,1006 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mauza/devmetrics/internal"
+)
+
+// editLookahead is how many planned commits ahead changes are generated
+const editLookahead = 10
+
+// errInterrupted stops a run after Ctrl-C once the current commit is rolled back
+var errInterrupted = errors.New("interrupted")
+
+// planRunner applies plans and records what it created in a run ledger
+type planRunner struct {
+	config *internal.Config
+	llm    *internal.LLMOperations
+	ledger *internal.RunLedger
+	plan   *internal.Plan
+	ctx    context.Context // cancelled on Ctrl-C or SIGTERM
+}
+
+// newPlanRunner connects to the configured LLM for a run. Responses are
+// cached next to the run's ledger so a resumed run does not ask again, and
+// in the shared cache so other runs of the same scenario do not either.
+func newPlanRunner(config *internal.Config, ledger *internal.RunLedger, plan *internal.Plan) (*planRunner, error) {
+	llm, err := internal.ConnectLLM(config.LLM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize LLM: %w", err)
+	}
+
+	cache, err := internal.LoadResponseCache(internal.RunFile(config.StateDir, ledger.ID, "llm-cache.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	llm.SetCache(cache)
+	// Offline responses are cheaper to generate than to look up
+	if !config.Cache.Disabled && config.LLM.Provider != internal.ProviderOffline {
+		llm.SetStore(internal.OpenResponseStore(config.Cache.Dir))
+	}
+
+	return &planRunner{
+		config: config,
+		llm:    llm,
+		ledger: ledger,
+		plan:   plan,
+	}, nil
+}
+
+// run applies the plan to every repository the ledger has not finished yet.
+// Cancelling ctx stops the run after rolling back the commit in progress, so
+// it can be resumed later.
+func (r *planRunner) run(ctx context.Context) error {
+	defer r.llm.Close()
+	r.ctx = ctx
+
+	r.saveLedger()
+	r.savePlan()
+
+	for i := range r.plan.Repositories {
+		repoPlan := &r.plan.Repositories[i]
+
+		previous := r.ledger.FindRepository(repoPlan.Path)
+		if previous != nil && previous.Checkpoint.Done {
+			fmt.Printf("Skipping %s: already finished\n", repoPlan.Path)
+			continue
+		}
+
+		sandboxed := r.config.Sandbox.Enabled
+		workPath := repoPlan.Path
+		switch {
+		case previous != nil:
+			sandboxed = previous.Sandbox
+			if sandboxed {
+				workPath = previous.WorkPath
+			}
+		case sandboxed:
+			var err error
+			workPath, err = internal.CloneSandbox(repoPlan.Path, r.config.Sandbox.Dir)
+			if err != nil {
+				fmt.Printf("Skipping repository due to error: %v\n", err)
+				continue
+			}
+		}
+
+		var err error
+		if previous != nil {
+			err = r.resumeRepository(workPath, repoPlan, previous)
+		} else {
+			err = r.applyRepository(workPath, repoPlan)
+		}
+		if errors.Is(err, errInterrupted) {
+			r.saveLedger()
+			return fmt.Errorf("run %s interrupted, continue it with generate --resume %s", r.ledger.ID, r.ledger.ID)
+		}
+		if err != nil {
+			fmt.Printf("Skipping repository due to error: %v\n", err)
+		}
+
+		if sandboxed {
+			// An unfinished clone is what --resume continues from, so it is
+			// only emitted, and possibly removed, once it is done
+			if run := r.ledger.FindRepository(repoPlan.Path); run != nil && run.Checkpoint.Done {
+				if err := emitSandbox(r.config.Sandbox, repoPlan.Path, workPath); err != nil {
+					fmt.Printf("Failed to emit sandbox for %s: %v\n", repoPlan.Path, err)
+				}
+			} else {
+				fmt.Printf("Keeping unfinished sandbox for %s: %s\n", repoPlan.Path, workPath)
+			}
+		}
+	}
+
+	// A repository that failed partway can still be resumed
+	finished := true
+	for _, run := range r.ledger.Repositories {
+		finished = finished && run.Checkpoint.Done
+	}
+	if finished {
+		r.ledger.Finish()
+	}
+	r.saveLedger()
+	fmt.Printf("Run %s created %d commits\n", r.ledger.ID, r.ledger.NumCommits())
+	if requests, hits := r.llm.CacheHits(); hits > 0 {
+		fmt.Printf("Answered %d of %d LLM requests from cache\n", hits, requests)
+	}
+	if !finished {
+		fmt.Printf("Run %s is incomplete, continue it with generate --resume %s\n", r.ledger.ID, r.ledger.ID)
+	}
+
+	return nil
+}
+
+// interrupted returns errInterrupted once the run has been cancelled
+func (r *planRunner) interrupted() error {
+	if r.ctx.Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
+
+// saveLedger persists the ledger, reporting rather than failing on errors so
+// a ledger problem never aborts a half-applied run
+func (r *planRunner) saveLedger() {
+	if err := internal.SaveLedger(r.config.StateDir, r.ledger); err != nil {
+		fmt.Printf("Failed to save run ledger: %v\n", err)
+	}
+}
+
+// savePlan keeps the plan as it is being applied next to the ledger, so a
+// resumed run sees exactly the schedule the interrupted one used
+func (r *planRunner) savePlan() {
+	if err := internal.SavePlan(r.plan, internal.RunFile(r.config.StateDir, r.ledger.ID, "plan.json")); err != nil {
+		fmt.Printf("Failed to save run plan: %v\n", err)
+	}
+}
+
+// repositoryConfig returns the configured settings for a repository path,
+// falling back to defaults for repositories given on the command line
+func (r *planRunner) repositoryConfig(path string) internal.Repository {
+	for _, repo := range r.config.Repositories {
+		if filepath.Clean(repo.Path) == filepath.Clean(path) {
+			return repo
+		}
+	}
+	return internal.Repository{Path: path}
+}
+
+// applyHistoryPolicy sorts the planned commits and checks them against the
+// date of the commit they will be built on. It reports whether the commits
+// must go onto an orphan branch.
+func (r *planRunner) applyHistoryPolicy(gitOps *internal.GitOperations, repoPlan *internal.RepositoryPlan) (bool, error) {
+	repoPlan.SortCommits()
+
+	base := "HEAD"
+	switch {
+	case repoPlan.Branch != "" && gitOps.HasBranch(repoPlan.Branch):
+		base = "refs/heads/" + repoPlan.Branch
+	case repoPlan.BaseRef != "":
+		base = repoPlan.BaseRef
+	}
+
+	baseTime, err := gitOps.CommitTime(base)
+	if err != nil {
+		return false, err
+	}
+
+	if repoPlan.StartsAfter(baseTime) {
+		return false, nil
+	}
+
+	switch r.config.HistoryPolicy {
+	case internal.HistoryShift:
+		now := time.Now()
+		shifted, squeezed, err := repoPlan.ShiftBetween(baseTime, now)
+		if err != nil {
+			return false, fmt.Errorf("cannot shift the schedule after %s, dated %s: %w; use --history-policy orphan", base, baseTime.Format(time.RFC3339), err)
+		}
+		if squeezed {
+			fmt.Printf("Squeezed schedule in between %s and now\n", baseTime.Format(time.RFC3339))
+		} else {
+			fmt.Printf("Shifted schedule forward %d days to start after %s\n", shifted, baseTime.Format(time.RFC3339))
+		}
+		return false, nil
+	case internal.HistoryOrphan:
+		return true, nil
+	default:
+		return false, fmt.Errorf("schedule starts at %s, before %s is dated %s; use --history-policy shift or orphan",
+			repoPlan.Commits[0].Pattern.Timestamp.Format(time.RFC3339), base, baseTime.Format(time.RFC3339))
+	}
+}
+
+// openRepository opens the repository at path with the plan's backend
+func (r *planRunner) openRepository(path string, repoPlan *internal.RepositoryPlan) (*internal.GitOperations, error) {
+	gitOps, err := internal.NewGitOperations(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify repository access
+	if err := gitOps.VerifyRepoAccess(); err != nil {
+		return nil, fmt.Errorf("access issues: %w", err)
+	}
+
+	if repoPlan.Backend == internal.BackendObjects {
+		if err := gitOps.UseObjectBackend(); err != nil {
+			return nil, err
+		}
+	} else if gitOps.IsBare() {
+		return nil, fmt.Errorf("bare repositories require the %s backend", internal.BackendObjects)
+	}
+
+	return gitOps, nil
+}
+
+// applyRepository creates the planned commits in the repository at path
+func (r *planRunner) applyRepository(path string, repoPlan *internal.RepositoryPlan) error {
+	gitOps, err := r.openRepository(path, repoPlan)
+	if err != nil {
+		return err
+	}
+
+	orphan, err := r.applyHistoryPolicy(gitOps, repoPlan)
+	if err != nil {
+		return err
+	}
+
+	createdBranch := false
+	switch {
+	case orphan:
+		if repoPlan.Branch == "" {
+			repoPlan.Branch = internal.ExpandBranchName("devmetrics/orphan-{date}", time.Now())
+		}
+		if err := gitOps.CheckoutOrphan(repoPlan.Branch); err != nil {
+			return err
+		}
+		createdBranch = true
+		fmt.Printf("Committing onto orphan branch %s\n", repoPlan.Branch)
+	case repoPlan.Branch != "":
+		createdBranch, err = gitOps.CheckoutBranch(repoPlan.Branch, repoPlan.BaseRef)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Committing onto branch %s\n", repoPlan.Branch)
+	}
+	if repoPlan.Branch != "" {
+		defer func() {
+			if err := gitOps.RestoreCheckout(); err != nil {
+				fmt.Printf("Failed to restore original checkout: %v\n", err)
+			}
+		}()
+	}
+
+	currentBranch, baseCommit := repoPlan.Branch, ""
+	if !orphan {
+		var head plumbing.Hash
+		currentBranch, head, err = gitOps.Head()
+		if err != nil {
+			return err
+		}
+		baseCommit = head.String()
+	}
+
+	run := r.ledger.AddRepository(internal.RepositoryRun{
+		Path:          repoPlan.Path,
+		Sandbox:       r.config.Sandbox.Enabled,
+		Branch:        currentBranch,
+		CreatedBranch: createdBranch,
+		BaseCommit:    baseCommit,
+	})
+	if r.config.Sandbox.Enabled {
+		run.WorkPath = path
+	}
+	r.saveLedger()
+
+	// The schedule may have been sorted, shifted or given a branch above
+	r.savePlan()
+
+	fmt.Printf("Generating commits for %s\n", path)
+	return r.applyCommits(gitOps, run, repoPlan)
+}
+
+// resumeRepository continues applying a repository's plan from its last
+// checkpoint
+func (r *planRunner) resumeRepository(path string, repoPlan *internal.RepositoryPlan, run *internal.RepositoryRun) error {
+	// Work done after the checkpoint is redone, so drop whatever of it made
+	// it into the repository
+	restored, err := internal.RestoreRunRefs(path, r.ledger.ID, run.BranchTips(), run.Tags)
+	if err != nil {
+		return err
+	}
+	for _, ref := range restored {
+		fmt.Printf("Restored %s to the last checkpoint\n", ref)
+	}
+
+	// The commit after the checkpoint may have been interrupted halfway
+	if run.Checkpoint.Position < len(repoPlan.Commits) && repoPlan.Backend != internal.BackendObjects {
+		if err := internal.DiscardChanges(path, r.commitPaths(repoPlan.Commits[run.Checkpoint.Position])); err != nil {
+			return err
+		}
+	}
+
+	gitOps, err := r.openRepository(path, repoPlan)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case run.Branch == "":
+		// A detached HEAD must still be where the run left it
+		_, head, err := gitOps.Head()
+		if err != nil {
+			return err
+		}
+		expected := run.LastCommit()
+		if expected == "" {
+			expected = run.BaseCommit
+		}
+		if head.String() != expected {
+			return fmt.Errorf("HEAD has moved to %s since the run was interrupted (expected %s)", head, expected)
+		}
+	case gitOps.HasBranch(run.Branch):
+		if _, err := gitOps.CheckoutBranch(run.Branch, ""); err != nil {
+			return err
+		}
+	default:
+		// An orphan branch that never got its first commit
+		if err := gitOps.CheckoutOrphan(run.Branch); err != nil {
+			return err
+		}
+	}
+	if run.Branch != "" {
+		defer func() {
+			if err := gitOps.RestoreCheckout(); err != nil {
+				fmt.Printf("Failed to restore original checkout: %v\n", err)
+			}
+		}()
+	}
+
+	fmt.Printf("Resuming %s at planned commit %d of %d\n", repoPlan.Path, run.Checkpoint.Position+1, len(repoPlan.Commits))
+	return r.applyCommits(gitOps, run, repoPlan)
+}
+
+// commitPaths returns every path a planned commit may write to
+func (r *planRunner) commitPaths(planned internal.PlannedCommit) []string {
+	paths := planned.Paths()
+	if planned.Pattern.Release != "" {
+		for _, path := range []string{r.config.Releases.VersionFile, r.config.Releases.Changelog} {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// applyCommits creates the planned commits from the checkpoint on, saving a
+// new checkpoint after each one
+func (r *planRunner) applyCommits(gitOps *internal.GitOperations, run *internal.RepositoryRun, repoPlan *internal.RepositoryPlan) error {
+	repo := &repoRunner{
+		planRunner:  r,
+		gitOps:      gitOps,
+		run:         run,
+		eligibility: internal.NewFileEligibility(r.repositoryConfig(repoPlan.Path)),
+		edits:       internal.NewEditPool(r.ctx, r.llm, r.config.LLM.Concurrency),
+	}
+	defer repo.edits.Close()
+	// The object backend only updates the worktree once it stops committing,
+	// including when it stops early
+	defer func() {
+		if err := gitOps.Finish(); err != nil {
+			fmt.Printf("Failed to update worktree: %v\n", err)
+		}
+	}()
+
+	// Topic branches need a named branch to start from and merge back into
+	if run.Branch != "" {
+		repo.topics = newTopicBranches(gitOps, run, r.saveLedger)
+	} else if repoPlan.HasTopicBranches() {
+		fmt.Printf("HEAD is detached, committing topic branch work directly onto it\n")
+	}
+
+	var err error
+	repo.releases, err = newReleaseBranches(gitOps, run, r.config.Releases, r.saveLedger)
+	if err != nil {
+		return err
+	}
+
+	for i := run.Checkpoint.Position; i < len(repoPlan.Commits); i++ {
+		repo.prefetch(repoPlan.Commits[i:])
+
+		err := r.interrupted()
+		if err == nil {
+			err = repo.applyCommit(repoPlan.Commits[i])
+		}
+		repo.edits.Forget(repoPlan.Commits[i].Pattern)
+		// LLM requests cut short by the cancellation fail with their own errors
+		if err != nil && r.interrupted() != nil {
+			err = errInterrupted
+		}
+
+		// Every commit is all or nothing, so whatever it did not commit is
+		// discarded, whether it failed, was interrupted or had nothing to do
+		if err := gitOps.Rollback(); err != nil {
+			return fmt.Errorf("failed to roll back changes: %w", err)
+		}
+		if errors.Is(err, errInterrupted) {
+			// Leave HEAD on the run's branch rather than a topic or release branch
+			if _, err := repo.topics.switchTo(""); err != nil {
+				fmt.Printf("Failed to switch branch: %v\n", err)
+			}
+			return err
+		}
+		if err != nil {
+			fmt.Printf("Skipping commit: %v\n", err)
+		}
+
+		// The object backend publishes its commits at every checkpoint so
+		// the ledger never records commits no ref points to
+		if err := gitOps.PublishRefs(); err != nil {
+			return err
+		}
+		run.Checkpoint.Position = i + 1
+		r.saveLedger()
+	}
+
+	if _, err := repo.topics.switchTo(""); err != nil {
+		return err
+	}
+	if err := gitOps.Finish(); err != nil {
+		return err
+	}
+
+	run.Checkpoint.Done = true
+	r.saveLedger()
+	return nil
+}
+
+// repoRunner applies planned commits to one repository
+type repoRunner struct {
+	*planRunner
+	gitOps      *internal.GitOperations
+	run         *internal.RepositoryRun
+	eligibility *internal.FileEligibility
+	topics      *topicBranches
+	releases    *releaseBranches
+	edits       *internal.EditPool
+}
+
+// prefetch starts generating the changes of the next planned commits. Files
+// an earlier one of them touches are left alone, since their content is not
+// known yet, and nothing past a merge is, since it brings in other changes.
+func (r *repoRunner) prefetch(upcoming []internal.PlannedCommit) {
+	if len(upcoming) > editLookahead {
+		upcoming = upcoming[:editLookahead]
+	}
+
+	touched := make(map[string]bool)
+	for _, planned := range upcoming {
+		if planned.Pattern.MergeBranch != "" {
+			return
+		}
+
+		for _, filePath := range planned.Files {
+			if touched[filePath] {
+				continue
+			}
+			content, err := r.gitOps.ReadFile(filePath)
+			if err != nil || r.eligibility.CheckContent(filePath, content) != "" {
+				continue
+			}
+			r.edits.Prefetch(planned.Pattern, filePath, content)
+		}
+
+		for _, path := range r.commitPaths(planned) {
+			touched[path] = true
+		}
+	}
+}
+
+// applyCommit creates a single planned commit. Changes are left in place
+// when it fails, for the caller to roll back.
+func (r *repoRunner) applyCommit(planned internal.PlannedCommit) error {
+	pattern := planned.Pattern
+
+	if pattern.MergeBranch != "" {
+		if r.topics == nil {
+			return nil
+		}
+		return r.mergeTopic(pattern)
+	}
+
+	if pattern.Release != "" {
+		return r.release(pattern)
+	}
+
+	var topic *internal.TopicProgress
+	var err error
+	onBranch := ""
+	if pattern.Hotfix && r.releases.canBranch() {
+		onBranch, err = r.releases.switchTo()
+	} else {
+		topic, err = r.topics.switchTo(pattern.Branch)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to switch branch: %w", err)
+	}
+	if topic != nil {
+		onBranch = topic.Name
+	}
+
+	// Add, delete and rename files first so modifications see the result
+	changesDescription, touched, diffs, err := r.applyFileOperations(planned)
+	if err != nil {
+		return err
+	}
+
+	// Structured output proposes a commit message with every file, the one
+	// proposed with the largest change is used
+	proposed, proposedSize := "", 0
+
+	// Modify each file
+	for _, filePath := range planned.Files {
+		if err := r.interrupted(); err != nil {
+			return err
+		}
+
+		content, err := r.gitOps.ReadFile(filePath)
+		if err != nil {
+			continue
+		}
+
+		// Files may have changed since the plan was written
+		if reason := r.eligibility.CheckContent(filePath, content); reason != "" {
+			fmt.Printf("Skipping %s: %s\n", filePath, reason)
+			continue
+		}
+
+		// Generate changes using LLM
+		edit, err := r.edits.EditFile(r.ctx, pattern, filePath, content)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", filePath, err)
+			continue
+		}
+
+		// Apply changes
+		if err := r.gitOps.ModifyFile(filePath, edit.Content); err != nil {
+			continue
+		}
+
+		changesDescription = append(changesDescription,
+			fmt.Sprintf("%s: %s", filepath.Base(filePath), edit.Description))
+		touched = append(touched, filePath)
+		diff := internal.NewFileDiff(filePath, content, edit.Content)
+		diffs = append(diffs, diff)
+		if size := diff.Added + diff.Removed; edit.Message != "" && (proposed == "" || size > proposedSize) {
+			proposed, proposedSize = edit.Message, size
+		}
+	}
+
+	if len(changesDescription) == 0 {
+		return nil
+	}
+	if err := r.interrupted(); err != nil {
+		return err
+	}
+
+	// Generate commit message
+	changesSummary := fmt.Sprintf("%s\n\nChanges:\n%s",
+		pattern.Description,
+		formatChanges(changesDescription))
+
+	var commitMsg string
+	switch {
+	case proposed != "" && len(changesDescription) == 1:
+		commitMsg = proposed
+	case proposed != "":
+		// The proposal only knows about its own file
+		commitMsg = fmt.Sprintf("%s\n\n%s", internal.Subject(proposed), formatChanges(changesDescription))
+	default:
+		commitMsg, err = r.llm.GenerateCommitMessage(r.ctx, changesSummary, diffs)
+		if err != nil {
+			return err
+		}
+	}
+	if err := r.interrupted(); err != nil {
+		return err
+	}
+
+	provenance := internal.Provenance{RunID: r.ledger.ID, Pattern: pattern}
+
+	// Create commit with pattern timestamp
+	hash, err := r.gitOps.CreateCommit(provenance.AppendTrailers(commitMsg), touched, &pattern.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	if r.config.Provenance.Notes {
+		note := internal.CommitNote{
+			RunID:        r.ledger.ID,
+			Pattern:      pattern,
+			Files:        touched,
+			Changes:      changesDescription,
+			CommitPrompt: changesSummary,
+			LLM:          internal.NewNoteLLM(r.config.LLM),
+		}
+		if err := r.gitOps.AddNote(r.config.Provenance.NotesRef, hash, note); err != nil {
+			fmt.Printf("Failed to add provenance note: %v\n", err)
+		}
+	}
+
+	subject := internal.Subject(commitMsg)
+	if topic != nil {
+		topic.Subjects = append(topic.Subjects, subject)
+	}
+	r.releases.recordChange(pattern, subject)
+
+	r.run.Commits = append(r.run.Commits, internal.LedgerCommit{
+		Hash:       hash.String(),
+		CommitDate: pattern.Timestamp,
+		CreatedAt:  time.Now(),
+		Branch:     onBranch,
+	})
+	fmt.Printf("Created commit: %s\n", commitMsg)
+	return nil
+}
+
+// applyFileOperations creates, deletes and renames the files of a planned
+// commit. It returns a description of each change that succeeded, the paths
+// to stage for them and their diffs.
+func (r *repoRunner) applyFileOperations(planned internal.PlannedCommit) ([]string, []string, []internal.FileDiff, error) {
+	var changes, touched []string
+	var diffs []internal.FileDiff
+
+	for _, rename := range planned.Renamed {
+		if err := r.gitOps.RenameFile(rename.From, rename.To); err != nil {
+			fmt.Printf("Skipping rename of %s: %v\n", rename.From, err)
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: moved to %s", rename.From, rename.To))
+		touched = append(touched, rename.From, rename.To)
+		diffs = append(diffs, internal.FileDiff{Path: rename.To, From: rename.From})
+	}
+
+	for _, filePath := range planned.Deleted {
+		// A file that cannot be read is still deleted, with an empty diff
+		content, _ := r.gitOps.ReadFile(filePath)
+		if err := r.gitOps.DeleteFile(filePath); err != nil {
+			fmt.Printf("Skipping deletion of %s: %v\n", filePath, err)
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: removed", filePath))
+		touched = append(touched, filePath)
+		diffs = append(diffs, internal.NewFileDiff(filePath, content, ""))
+	}
+
+	for _, added := range planned.Added {
+		if err := r.interrupted(); err != nil {
+			return nil, nil, nil, err
+		}
+
+		if reason := r.eligibility.CheckPath(added.Path); reason != "" {
+			fmt.Printf("Skipping %s: %s\n", added.Path, reason)
+			continue
+		}
+
+		// New files follow the template's style, which may have been renamed
+		// or deleted since the plan was written
+		template, err := r.gitOps.ReadFile(added.Template)
+		if err != nil {
+			template = ""
+		}
+
+		content, summary, err := r.llm.GenerateNewFile(r.ctx, added.Path, planned.Pattern.Description, added.Template, template)
+		if err != nil {
+			fmt.Printf("Skipping %s: %v\n", added.Path, err)
+			continue
+		}
+
+		if err := r.gitOps.ModifyFile(added.Path, content); err != nil {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s (new): %s", filepath.Base(added.Path), summary))
+		touched = append(touched, added.Path)
+		diffs = append(diffs, internal.NewFileDiff(added.Path, "", content))
+	}
+
+	return changes, touched, diffs, nil
+}
+
+// mergeTopic merges a topic branch back into the run's branch, or squashes
+// it into a single commit listing the topic's commits
+func (r *repoRunner) mergeTopic(pattern internal.CommitPattern) error {
+	topic := r.topics.planned(pattern.MergeBranch)
+	if topic == nil || len(topic.Subjects) == 0 {
+		// Nothing was committed on the branch
+		return nil
+	}
+
+	if _, err := r.topics.switchTo(""); err != nil {
+		return fmt.Errorf("failed to switch branch: %w", err)
+	}
+
+	message := fmt.Sprintf("Merge branch '%s'", topic.Name)
+	if pattern.Squash {
+		message = fmt.Sprintf("%s\n\n%s", pattern.Description, formatChanges(topic.Subjects))
+	}
+
+	provenance := internal.Provenance{RunID: r.ledger.ID, Pattern: pattern}
+	hash, err := r.gitOps.MergeBranch(topic.Name, provenance.AppendTrailers(message), pattern.Timestamp, pattern.Squash)
+	if err != nil {
+		return fmt.Errorf("failed to merge %s: %w", topic.Name, err)
+	}
+
+	if r.config.Provenance.Notes {
+		note := internal.CommitNote{
+			RunID:   r.ledger.ID,
+			Pattern: pattern,
+			Changes: topic.Subjects,
+			LLM:     internal.NewNoteLLM(r.config.LLM),
+		}
+		if err := r.gitOps.AddNote(r.config.Provenance.NotesRef, hash, note); err != nil {
+			fmt.Printf("Failed to add provenance note: %v\n", err)
+		}
+	}
+
+	r.run.Commits = append(r.run.Commits, internal.LedgerCommit{
+		Hash:       hash.String(),
+		CommitDate: pattern.Timestamp,
+		CreatedAt:  time.Now(),
+	})
+	fmt.Printf("Created commit: %s\n", internal.Subject(message))
+	return nil
+}
+
+// release bumps the configured version files and tags the result. Hotfix
+// releases happen on the release branch of the latest release.
+func (r *repoRunner) release(pattern internal.CommitPattern) error {
+	var onBranch string
+	var err error
+	if pattern.Hotfix && r.releases.canBranch() {
+		onBranch, err = r.releases.switchTo()
+	} else {
+		_, err = r.topics.switchTo("")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to switch branch: %w", err)
+	}
+
+	version := r.releases.version.Bump(pattern.Release)
+	tag := r.config.Releases.TagPrefix + version.String()
+	if r.gitOps.HasTag(tag) {
+		fmt.Printf("Skipping release: tag %s already exists\n", tag)
+		return nil
+	}
+
+	_, hash, err := r.gitOps.Head()
+	if err != nil {
+		return fmt.Errorf("failed to release %s: %w", tag, err)
+	}
+
+	changes := r.releases.changes(pattern.Hotfix)
+	provenance := internal.Provenance{RunID: r.ledger.ID, Pattern: pattern}
+
+	if files := r.bumpReleaseFiles(version, pattern.Timestamp, changes); len(files) > 0 {
+		message := provenance.AppendTrailers(fmt.Sprintf("chore(release): %s", tag))
+		hash, err = r.gitOps.CreateCommit(message, files, &pattern.Timestamp)
+		if err != nil {
+			return fmt.Errorf("failed to create release commit: %w", err)
+		}
+
+		r.run.Commits = append(r.run.Commits, internal.LedgerCommit{
+			Hash:       hash.String(),
+			CommitDate: pattern.Timestamp,
+			CreatedAt:  time.Now(),
+			Branch:     onBranch,
+		})
+	}
+	if hash.IsZero() {
+		fmt.Printf("Skipping release %s: nothing has been committed yet\n", tag)
+		return nil
+	}
+
+	if err := r.gitOps.CreateTag(tag, hash, provenance.AppendTrailers("Release "+tag), pattern.Timestamp); err != nil {
+		return fmt.Errorf("failed to tag release: %w", err)
+	}
+
+	r.run.Tags = append(r.run.Tags, tag)
+	r.releases.released(version, hash, pattern.Hotfix)
+	fmt.Printf("Tagged release %s\n", tag)
+	return nil
+}
+
+// bumpReleaseFiles writes the configured version file and changelog for a
+// release and returns the files to commit
+func (r *repoRunner) bumpReleaseFiles(version internal.Version, date time.Time, changes []string) []string {
+	cfg := r.config.Releases
+
+	var files []string
+	bump := func(path string, update func(string) string) {
+		// A file that cannot be read is created from scratch
+		content, _ := r.gitOps.ReadFile(path)
+		if err := r.gitOps.ModifyFile(path, update(content)); err != nil {
+			fmt.Printf("Failed to update %s: %v\n", path, err)
+			return
+		}
+		files = append(files, path)
+	}
+
+	if cfg.VersionFile != "" {
+		bump(cfg.VersionFile, func(content string) string {
+			return internal.BumpVersionFile(content, version)
+		})
+	}
+	if cfg.Changelog != "" {
+		bump(cfg.Changelog, func(content string) string {
+			return internal.AddChangelogEntry(content, version, date, changes)
+		})
+	}
+
+	return files
+}
+
+func formatChanges(changes []string) string {
+	var result string
+	for _, change := range changes {
+		result += fmt.Sprintf("- %s\n", change)
+	}
+	return result
+}
+
+// topicBranches tracks the topic branches created in one repository for the
+// topic branch names used in a plan. Progress lives in the run's checkpoint.
+type topicBranches struct {
+	gitOps *internal.GitOperations
+	run    *internal.RepositoryRun
+	save   func() // saves the ledger before a branch is created
+}
+
+func newTopicBranches(gitOps *internal.GitOperations, run *internal.RepositoryRun, save func()) *topicBranches {
+	if run.Checkpoint.Topics == nil {
+		run.Checkpoint.Topics = make(map[string]*internal.TopicProgress)
+	}
+	return &topicBranches{gitOps: gitOps, run: run, save: save}
+}
+
+// planned returns the branch created for a topic branch name in the plan
+func (t *topicBranches) planned(name string) *internal.TopicProgress {
+	return t.run.Checkpoint.Topics[name]
+}
+
+// switchTo makes the topic branch planned as name the target of new commits,
+// creating it from the run's branch the first time. An empty name switches
+// back to the run's branch, in which case no topic is returned.
+func (t *topicBranches) switchTo(name string) (*internal.TopicProgress, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if name == "" {
+		return nil, t.gitOps.SwitchBranch(t.run.Branch)
+	}
+
+	topic := t.planned(name)
+	if topic == nil {
+		actual := name
+		for i := 2; t.gitOps.HasBranch(actual); i++ {
+			actual = fmt.Sprintf("%s-%d", name, i)
+		}
+
+		base, err := t.gitOps.BranchPoint(t.run.Branch)
+		if errors.Is(err, internal.ErrUnbornBranch) {
+			// There is nothing to branch from yet, so the work goes on the run's branch
+			return nil, t.gitOps.SwitchBranch(t.run.Branch)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Record the branch first, so a resumed run knows about it even if
+		// it was interrupted while the branch was being created
+		topic = &internal.TopicProgress{Name: actual}
+		t.run.Checkpoint.Topics[name] = topic
+		t.run.TopicBranches = append(t.run.TopicBranches, actual)
+		t.run.RecordBranch(actual, base.String())
+		t.save()
+
+		if err := t.gitOps.StartBranchAt(actual, base); err != nil {
+			return nil, err
+		}
+	}
+
+	return topic, t.gitOps.SwitchBranch(topic.Name)
+}
+
+// releaseBranches tracks the latest release and the release branch hotfixes
+// for it go to. Unreleased changes live in the run's checkpoint.
+type releaseBranches struct {
+	gitOps  *internal.GitOperations
+	run     *internal.RepositoryRun
+	cfg     internal.ReleaseConfig
+	version internal.Version // latest release
+	commit  plumbing.Hash    // commit tagged with the latest release
+	known   map[string]bool  // release branches that exist
+	save    func()           // saves the ledger before a branch is created
+}
+
+func newReleaseBranches(gitOps *internal.GitOperations, run *internal.RepositoryRun, cfg internal.ReleaseConfig, save func()) (*releaseBranches, error) {
+	version, commit, err := gitOps.LatestVersion(cfg.TagPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, name := range run.ReleaseBranches {
+		known[name] = true
+	}
+
+	return &releaseBranches{
+		gitOps:  gitOps,
+		run:     run,
+		cfg:     cfg,
+		version: version,
+		commit:  commit,
+		known:   known,
+		save:    save,
+	}, nil
+}
+
+// canBranch reports whether hotfixes can go on a release branch, which needs
+// a release to branch from and a named branch to return to
+func (t *releaseBranches) canBranch() bool {
+	return t.run.Branch != "" && !t.commit.IsZero()
+}
+
+// switchTo makes the release branch of the latest release the target of new
+// commits, creating it at the release the first time
+func (t *releaseBranches) switchTo() (string, error) {
+	name := fmt.Sprintf("%s%d.%d", t.cfg.BranchPrefix, t.version.Major, t.version.Minor)
+	if !t.known[name] {
+		if !t.gitOps.HasBranch(name) {
+			t.run.ReleaseBranches = append(t.run.ReleaseBranches, name)
+			t.run.RecordBranch(name, t.commit.String())
+			t.save()
+
+			if err := t.gitOps.StartBranchAt(name, t.commit); err != nil {
+				return "", err
+			}
+		}
+		t.known[name] = true
+	}
+
+	return name, t.gitOps.SwitchBranch(name)
+}
+
+// recordChange remembers a commit subject for the next release's changelog
+func (t *releaseBranches) recordChange(pattern internal.CommitPattern, subject string) {
+	if pattern.Hotfix {
+		t.run.Checkpoint.Hotfixes = append(t.run.Checkpoint.Hotfixes, subject)
+	} else {
+		t.run.Checkpoint.Changes = append(t.run.Checkpoint.Changes, subject)
+	}
+}
+
+// changes returns the commit subjects going into the next release
+func (t *releaseBranches) changes(hotfix bool) []string {
+	if hotfix {
+		return t.run.Checkpoint.Hotfixes
+	}
+	return t.run.Checkpoint.Changes
+}
+
+// released records a new release and starts collecting changes for the next
+func (t *releaseBranches) released(version internal.Version, commit plumbing.Hash, hotfix bool) {
+	t.version = version
+	t.commit = commit
+	if hotfix {
+		t.run.Checkpoint.Hotfixes = nil
+	} else {
+		t.run.Checkpoint.Changes = nil
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mauza/devmetrics/internal"
+	"github.com/spf13/cobra"
+)
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Inspect recorded generation runs",
+}
+
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recorded generation runs",
+	RunE:  runRunsList,
+}
+
+func init() {
+	runsCmd.AddCommand(runsListCmd)
+}
+
+func runRunsList(cmd *cobra.Command, args []string) error {
+	config, err := internal.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	ledgers, err := internal.ListLedgers(config.StateDir)
+	if err != nil {
+		return err
+	}
+
+	if len(ledgers) == 0 {
+		fmt.Printf("No runs recorded in %s\n", config.StateDir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tSTARTED\tPERSONA\tREPOS\tCOMMITS\tSTATUS")
+	for _, ledger := range ledgers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n",
+			ledger.ID,
+			ledger.StartedAt.Format("2006-01-02 15:04"),
+			ledger.Persona,
+			len(ledger.Repositories),
+			ledger.NumCommits(),
+			ledger.Status())
+	}
+	return w.Flush()
+}
@@ -1,37 +1,123 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/mauza/devmetrics/internal"
 	"github.com/spf13/cobra"
 )
 
+var validateJSON bool
+
 var validateCmd = &cobra.Command{
-	Use:   "validate",
-	Short: "Validate configuration and repository access",
-	RunE:  runValidate,
+	Use:          "validate",
+	Short:        "Validate configuration and repository access",
+	RunE:         runValidate,
+	SilenceUsage: true,
 }
 
-func runValidate(cmd *cobra.Command, args []string) error {
-	fmt.Println("Validating configuration...")
+func init() {
+	validateCmd.Flags().BoolVar(&validateJSON, "json", false, "Print the report as JSON")
+}
+
+// validationReport is everything validate checked
+type validationReport struct {
+	Repositories []internal.RepositoryReport `json:"repositories"`
+	LLM          internal.Check              `json:"llm"`
+}
 
+func runValidate(cmd *cobra.Command, args []string) error {
 	config, err := internal.LoadConfig(configFile)
 	if err != nil {
 		return err
 	}
 
-	// Check repositories
+	var report validationReport
 	for _, repo := range config.Repositories {
-		path := filepath.Clean(repo.Path)
-		if _, err := os.Stat(path); err != nil {
-			fmt.Printf("Warning: Repository path does not exist: %s\n", path)
-		} else {
-			fmt.Printf("✓ Repository found: %s\n", path)
+		report.Repositories = append(report.Repositories, internal.ValidateRepository(repo))
+	}
+	report.LLM = internal.ValidateLLM(cmd.Context(), config.LLM)
+
+	if validateJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Println(string(data))
+	} else if err := printValidation(report); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, repo := range report.Repositories {
+		if repo.Status == internal.CheckFail {
+			failed++
+		}
+	}
+	if report.LLM.Status == internal.CheckFail {
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("validation failed for %d of %d checked targets", failed, len(report.Repositories)+1)
+	}
+
+	return nil
+}
+
+// printValidation prints a table of checks for each repository and the LLM,
+// followed by the files each repository skipped
+func printValidation(report validationReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tCHECK\tSTATUS\tDETAIL")
+	for _, repo := range report.Repositories {
+		fmt.Fprintf(w, "%s\t\t%s\t\n", repo.Path, strings.ToUpper(repo.Status))
+		for _, check := range repo.Checks {
+			fmt.Fprintf(w, "\t%s\t%s\t%s\n", check.Name, check.Status, check.Detail)
 		}
 	}
+	fmt.Fprintf(w, "LLM\t\t%s\t\n", strings.ToUpper(report.LLM.Status))
+	fmt.Fprintf(w, "\t%s\t%s\t%s\n", report.LLM.Name, report.LLM.Status, report.LLM.Detail)
+	if err := w.Flush(); err != nil {
+		return err
+	}
 
+	for _, repo := range report.Repositories {
+		if len(repo.Skipped) > 0 {
+			fmt.Printf("\n%s:\n", repo.Path)
+			reportSkipped(repo.Skipped)
+		}
+	}
 	return nil
 }
+
+// reportSkipped prints how many files were skipped for each reason with a
+// few examples of each
+func reportSkipped(skipped []internal.SkippedFile) {
+	const maxExamples = 3
+
+	var reasons []string
+	byReason := make(map[string][]string)
+	for _, file := range skipped {
+		if _, ok := byReason[file.Reason]; !ok {
+			reasons = append(reasons, file.Reason)
+		}
+		byReason[file.Reason] = append(byReason[file.Reason], file.Path)
+	}
+
+	for _, reason := range reasons {
+		paths := byReason[reason]
+		fmt.Printf("  skipped %d %s:", len(paths), reason)
+		for i, path := range paths {
+			if i == maxExamples {
+				fmt.Print(" ...")
+				break
+			}
+			fmt.Printf(" %s", path)
+		}
+		fmt.Println()
+	}
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 )
@@ -17,11 +18,19 @@ type DeveloperPersona struct {
 }
 
 type CommitPattern struct {
-	Timestamp   time.Time
-	NumFiles    int
-	ChangeType  string
-	CommitType  string
-	Description string
+	Timestamp   time.Time    `json:"timestamp"`
+	NumFiles    int          `json:"num_files"`
+	ChangeType  string       `json:"change_type"`
+	CommitType  string       `json:"commit_type"`
+	Description string       `json:"description"`
+	Persona     string       `json:"persona"`
+	SprintPhase ProjectPhase `json:"sprint_phase"`
+	FocusArea   string       `json:"focus_area,omitempty"`   // e.g. "backend/api", the part of the project the sprint works on
+	Branch      string       `json:"branch,omitempty"`       // topic branch the commit goes on, empty for the main branch
+	MergeBranch string       `json:"merge_branch,omitempty"` // set on merge commits to the topic branch being merged
+	Squash      bool         `json:"squash,omitempty"`       // merge as a single non-merge commit
+	Hotfix      bool         `json:"hotfix,omitempty"`       // goes on the release branch of the latest release
+	Release     string       `json:"release,omitempty"`      // set on release patterns to the version part to bump
 }
 
 type CommitPatternGenerator struct {
@@ -31,6 +40,8 @@ type CommitPatternGenerator struct {
 		Changes        []string
 	}
 	projectPatterns *ProjectPatternGenerator
+	workflow        WorkflowConfig
+	releases        bool
 }
 
 func NewCommitPatternGenerator() *CommitPatternGenerator {
@@ -80,12 +91,24 @@ func NewCommitPatternGenerator() *CommitPatternGenerator {
 		}{
 			"feature": {
 				FileCountRange: [2]int{2, 5},
-				Changes:        []string{"add_feature", "enhance_feature", "implement_feature"},
+				Changes:        []string{"add_feature", "enhance_feature", "implement_feature", "add_module"},
 			},
 			"fix": {
 				FileCountRange: [2]int{1, 3},
 				Changes:        []string{"fix_bug", "handle_edge_case", "improve_error_handling"},
 			},
+			"refactor": {
+				FileCountRange: [2]int{1, 4},
+				Changes:        []string{"refactor_code", "rename_file", "remove_dead_file"},
+			},
+			"docs": {
+				FileCountRange: [2]int{1, 2},
+				Changes:        []string{"update_docs", "add_docs"},
+			},
+			"test": {
+				FileCountRange: [2]int{1, 3},
+				Changes:        []string{"add_tests", "add_test_file"},
+			},
 			// ... add other commit types similarly
 		},
 	}
@@ -109,7 +132,10 @@ func (g *CommitPatternGenerator) GeneratePatterns(startDate, endDate time.Time,
 	sprintCycles := g.projectPatterns.GenerateSprintCycles(startDate, endDate)
 
 	var patterns []CommitPattern
+	topics := newTopicNamer(g.workflow.BranchPrefix)
 	for _, cycle := range sprintCycles {
+		var cyclePatterns []CommitPattern
+
 		// Adjust commit frequency based on sprint intensity
 		adjustedFreq := g.adjustFrequency(persona.CommitFreq, cycle.Intensity)
 
@@ -117,14 +143,57 @@ func (g *CommitPatternGenerator) GeneratePatterns(startDate, endDate time.Time,
 		for current := cycle.StartDate; current.Before(cycle.EndDate); current = current.AddDate(0, 0, 1) {
 			if current.Weekday() < 6 { // Skip weekends
 				dayCommits := g.generateDayCommits(current, &persona, cycle, adjustedFreq)
-				patterns = append(patterns, dayCommits...)
+				cyclePatterns = append(cyclePatterns, dayCommits...)
 			}
 		}
+
+		// Merges and releases follow the cycle's last commits but must not
+		// land after the cycle or in the future
+		end := cycle.EndDate
+		if now := time.Now(); now.Before(end) {
+			end = now
+		}
+		if g.workflow.FeatureBranches {
+			cyclePatterns = g.assignTopicBranches(cyclePatterns, topics, end)
+		}
+		if g.releases {
+			cyclePatterns = g.addRelease(cyclePatterns, cycle, end)
+		}
+		patterns = append(patterns, cyclePatterns...)
 	}
 
+	SortPatterns(patterns)
 	return patterns
 }
 
+// SortPatterns orders patterns chronologically and nudges identical
+// timestamps apart so commit dates strictly increase along the branch
+func SortPatterns(patterns []CommitPattern) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		return patterns[i].Timestamp.Before(patterns[j].Timestamp)
+	})
+
+	for i := 1; i < len(patterns); i++ {
+		if !patterns[i].Timestamp.After(patterns[i-1].Timestamp) {
+			patterns[i].Timestamp = patterns[i-1].Timestamp.Add(time.Second)
+		}
+	}
+}
+
+// followUpTime returns the time of a commit following last after delay, such
+// as a merge or a release, kept no later than end. It reports false when end
+// leaves no room after last.
+func followUpTime(last, end time.Time, delay time.Duration) (time.Time, bool) {
+	if t := last.Add(delay); !t.After(end) {
+		return t, true
+	}
+	room := int(end.Sub(last) / time.Second)
+	if room < 1 {
+		return time.Time{}, false
+	}
+	return last.Add(time.Duration(1+rand.Intn(room)) * time.Second), true
+}
+
 func (g *CommitPatternGenerator) adjustFrequency(baseFreq string, intensity float64) string {
 	frequencies := []string{"sparse", "moderate", "frequent"}
 	var baseIndex int
@@ -184,12 +253,20 @@ func (g *CommitPatternGenerator) generateDayCommits(
 			changeType = commitInfo.Changes[rand.Intn(len(commitInfo.Changes))]
 		}
 
+		area := ""
+		if len(sprint.FocusAreas) > 0 {
+			area = sprint.FocusAreas[rand.Intn(len(sprint.FocusAreas))]
+		}
+
 		patterns = append(patterns, CommitPattern{
 			Timestamp:   commitTime,
 			NumFiles:    numFiles,
 			ChangeType:  changeType,
 			CommitType:  commitType,
-			Description: g.generateCommitDescription(persona, commitType, sprint.FocusAreas),
+			Description: g.generateCommitDescription(persona, commitType, area),
+			Persona:     persona.Name,
+			SprintPhase: sprint.Phase,
+			FocusArea:   area,
 		})
 	}
 
@@ -245,6 +322,7 @@ func (g *CommitPatternGenerator) generateCommitTimes(
 		}
 	}
 
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
 	return times
 }
 
@@ -264,6 +342,9 @@ func (g *CommitPatternGenerator) adjustCodingStyle(
 		adjusted["fix"] = max(0.4, adjusted["fix"])
 	case PhaseRelease:
 		adjusted["docs"] = max(0.3, adjusted["docs"])
+	case PhaseHotfix:
+		// A hotfix is nothing but fixes
+		return map[string]float64{"fix": 1}
 	}
 
 	return adjusted
@@ -295,13 +376,12 @@ func (g *CommitPatternGenerator) selectCommitType(style map[string]float64) stri
 func (g *CommitPatternGenerator) generateCommitDescription(
 	persona *DeveloperPersona,
 	commitType string,
-	focusAreas []string,
+	area string,
 ) string {
-	if len(focusAreas) == 0 {
+	if area == "" {
 		return "Update codebase"
 	}
 
-	area := focusAreas[rand.Intn(len(focusAreas))]
 	parts := strings.Split(area, "/")
 	component := parts[0]
 	feature := "feature"
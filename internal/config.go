@@ -3,27 +3,113 @@ package internal
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	LLM          LLMConfig    `yaml:"llm"`
-	Repositories []Repository `yaml:"repositories"`
+	LLM          LLMConfig        `yaml:"llm"`
+	Repositories []Repository     `yaml:"repositories"`
+	Sandbox      SandboxConfig    `yaml:"sandbox"`
+	StateDir     string           `yaml:"state_dir"` // where run ledgers are kept, defaults to .devmetrics
+	Provenance   ProvenanceConfig `yaml:"provenance"`
+	// HistoryPolicy decides what to do when the schedule starts before the
+	// commit it builds on: "shift" (default), "orphan" or "refuse"
+	HistoryPolicy string         `yaml:"history_policy"`
+	Workflow      WorkflowConfig `yaml:"workflow"`
+	Releases      ReleaseConfig  `yaml:"releases"`
+	Cache         CacheConfig    `yaml:"cache"`
 }
 
 type Repository struct {
-	Path     string   `yaml:"path"`
-	Patterns []string `yaml:"patterns"`
+	Path        string   `yaml:"path"`
+	Patterns    []string `yaml:"patterns"`     // e.g. "*.go" matches in any directory, "src/**/*.ts" from the root
+	Exclude     []string `yaml:"exclude"`      // same syntax as patterns
+	TrackedOnly bool     `yaml:"tracked_only"` // only consider files in the git index
+	Backend     string   `yaml:"backend"`      // "worktree" (default) or "objects", required for bare repositories
+	// Files larger than MaxFileSize bytes are never sent to the LLM (default 100KB).
+	// Vendored directories, lockfiles and generated or minified files are
+	// skipped unless explicitly allowed.
+	MaxFileSize    int64  `yaml:"max_file_size"`
+	AllowVendored  bool   `yaml:"allow_vendored"`
+	AllowGenerated bool   `yaml:"allow_generated"`
+	Branch         string `yaml:"branch"`   // e.g. "devmetrics/demo-{date}", empty commits onto the current HEAD
+	BaseRef        string `yaml:"base_ref"` // ref new branches start from, defaults to HEAD
+	// Delete and rename commits only touch files created during the run
+	// unless RestructureExisting is set
+	RestructureExisting bool `yaml:"restructure_existing"`
 }
 
 type LLMConfig struct {
-	Provider     string  `yaml:"provider"`
+	Provider     string  `yaml:"provider"` // e.g. "openai", or "offline" to generate changes locally
 	Endpoint     string  `yaml:"endpoint"`
 	Model        string  `yaml:"model"`
 	MaxTokens    int     `yaml:"max_tokens"`
-	APIKeyEnvVar string  `yaml:"api_key_env_var"`
+	APIKeyEnvVar string  `yaml:"api_key_env_var"` // not needed by the offline provider
 	Temperature  float64 `yaml:"temperature"`
+	Seed         int64   `yaml:"seed"` // the offline provider makes the same changes for the same seed
+	// Each request is given up on after Timeout (default 300s, -1s waits as
+	// long as it takes) and retried up to Retries times (default 3, -1 never
+	// retries), waiting Backoff (default 2s) before the first retry and twice
+	// as long before each next
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+	// Concurrency is how many requests are made at once to generate the
+	// changes of upcoming commits ahead of time (default 4, 1 generates each
+	// change as its commit is applied). Requests are spaced out to stay within
+	// RequestsPerMinute and TokensPerMinute, zero for no limit.
+	Concurrency       int `yaml:"concurrency"`
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	TokensPerMinute   int `yaml:"tokens_per_minute"`
+	// Files estimated at more than PromptTokens are edited one function at
+	// a time (default 1500, -1 always sends the whole file)
+	PromptTokens int `yaml:"prompt_tokens"`
+	// Structured asks for each file's edit, its summary and a commit message
+	// in a single JSON response instead of one request each
+	Structured bool `yaml:"structured"`
+	// Validation decides when generated file content is rejected
+	Validation OutputValidationConfig `yaml:"validation"`
+	// Prompts are the instructions sent with code changes per commit type
+	// and change type
+	Prompts PromptConfig `yaml:"prompts"`
+}
+
+type OutputValidationConfig struct {
+	MaxChangeRatio float64 `yaml:"max_change_ratio"` // share of a file's lines one change may touch, defaults to 0.5
+	Attempts       int     `yaml:"attempts"`         // times a file is asked for before it is skipped, defaults to 3
+}
+
+type CacheConfig struct {
+	Disabled bool   `yaml:"disabled"` // always ask the LLM instead of replaying earlier responses
+	Dir      string `yaml:"dir"`      // defaults to the cache directory in the state dir
+}
+
+type SandboxConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`    // scratch directory for clones, defaults to the system temp dir
+	Output  string `yaml:"output"` // "directory" or "bundle"
+}
+
+type ProvenanceConfig struct {
+	Notes    bool   `yaml:"notes"`     // attach a git note with the full commit pattern and LLM settings
+	NotesRef string `yaml:"notes_ref"` // defaults to refs/notes/devmetrics
+}
+
+type WorkflowConfig struct {
+	FeatureBranches bool    `yaml:"feature_branches"` // commit features on topic branches and merge them back
+	SquashRatio     float64 `yaml:"squash_ratio"`     // share of topic branches squash-merged instead of merged, 0 to 1
+	BranchPrefix    string  `yaml:"branch_prefix"`    // defaults to "feature/"
+}
+
+type ReleaseConfig struct {
+	Enabled      bool   `yaml:"enabled"`       // tag a version at the end of release and hotfix phases
+	TagPrefix    string `yaml:"tag_prefix"`    // defaults to "v"
+	BranchPrefix string `yaml:"branch_prefix"` // release branches hotfixes go to, defaults to "release/"
+	Changelog    string `yaml:"changelog"`     // e.g. "CHANGELOG.md", empty leaves the changelog alone
+	VersionFile  string `yaml:"version_file"`  // e.g. "VERSION", its first x.y.z is replaced on release
 }
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -41,13 +127,119 @@ func LoadConfig(configPath string) (*Config, error) {
 	if len(config.Repositories) == 0 {
 		return nil, fmt.Errorf("no repositories configured in config.yaml")
 	}
-	if config.LLM.APIKeyEnvVar == "" {
+	if config.LLM.APIKeyEnvVar == "" && config.LLM.Provider != ProviderOffline {
 		return nil, fmt.Errorf("no LLM api key configuration in config.yaml")
 	}
+	if config.Provenance.NotesRef == "" {
+		config.Provenance.NotesRef = DefaultNotesRef
+	}
+	if err := validateHistoryPolicy(config.HistoryPolicy); err != nil {
+		return nil, err
+	}
+	for _, repo := range config.Repositories {
+		if err := ValidateBackend(repo.Backend); err != nil {
+			return nil, err
+		}
+	}
+	if config.HistoryPolicy == "" {
+		config.HistoryPolicy = HistoryShift
+	}
+	if config.StateDir == "" {
+		config.StateDir = ".devmetrics"
+	}
+	if config.Cache.Dir == "" {
+		config.Cache.Dir = filepath.Join(config.StateDir, "cache")
+	}
+	if config.LLM.Validation.MaxChangeRatio == 0 {
+		config.LLM.Validation.MaxChangeRatio = DefaultMaxChangeRatio
+	}
+	if config.LLM.Validation.MaxChangeRatio < 0 || config.LLM.Validation.MaxChangeRatio > 1 {
+		return nil, fmt.Errorf("invalid max change ratio %v, expected a value between 0 and 1", config.LLM.Validation.MaxChangeRatio)
+	}
+	if config.LLM.Validation.Attempts == 0 {
+		config.LLM.Validation.Attempts = DefaultOutputAttempts
+	}
+	if config.LLM.Validation.Attempts < 0 {
+		return nil, fmt.Errorf("invalid number of attempts %d", config.LLM.Validation.Attempts)
+	}
+	switch {
+	case config.LLM.Timeout == 0:
+		config.LLM.Timeout = DefaultLLMTimeout
+	case config.LLM.Timeout < 0:
+		config.LLM.Timeout = 0
+	}
+	switch {
+	case config.LLM.Retries == 0:
+		config.LLM.Retries = DefaultLLMRetries
+	case config.LLM.Retries < 0:
+		config.LLM.Retries = 0
+	}
+	if config.LLM.Backoff == 0 {
+		config.LLM.Backoff = DefaultLLMBackoff
+	}
+	if config.LLM.Backoff < 0 {
+		return nil, fmt.Errorf("invalid LLM backoff %s", config.LLM.Backoff)
+	}
+	if config.LLM.Concurrency == 0 {
+		config.LLM.Concurrency = DefaultLLMConcurrency
+	}
+	if config.LLM.Concurrency < 0 {
+		return nil, fmt.Errorf("invalid LLM concurrency %d", config.LLM.Concurrency)
+	}
+	if config.LLM.RequestsPerMinute < 0 || config.LLM.TokensPerMinute < 0 {
+		return nil, fmt.Errorf("invalid LLM rate limit, expected requests and tokens per minute of zero or more")
+	}
+	switch {
+	case config.LLM.PromptTokens == 0:
+		config.LLM.PromptTokens = DefaultPromptTokens
+	case config.LLM.PromptTokens < 0:
+		config.LLM.PromptTokens = 0
+	}
+	if _, err := NewPromptTemplates(config.LLM.Prompts); err != nil {
+		return nil, err
+	}
+	if config.Workflow.SquashRatio < 0 || config.Workflow.SquashRatio > 1 {
+		return nil, fmt.Errorf("invalid workflow squash ratio %v, expected a value between 0 and 1", config.Workflow.SquashRatio)
+	}
+	if config.Releases.TagPrefix == "" {
+		config.Releases.TagPrefix = DefaultTagPrefix
+	}
+	if config.Releases.BranchPrefix == "" {
+		config.Releases.BranchPrefix = DefaultReleaseBranchPrefix
+	}
+	if config.Workflow.BranchPrefix == "" {
+		config.Workflow.BranchPrefix = DefaultBranchPrefix
+	}
+	switch config.Sandbox.Output {
+	case "":
+		config.Sandbox.Output = SandboxOutputDirectory
+	case SandboxOutputDirectory, SandboxOutputBundle:
+	default:
+		return nil, fmt.Errorf("invalid sandbox output %q, expected %q or %q",
+			config.Sandbox.Output, SandboxOutputDirectory, SandboxOutputBundle)
+	}
 
 	return &config, nil
 }
 
+func validateHistoryPolicy(policy string) error {
+	switch policy {
+	case "", HistoryRefuse, HistoryOrphan, HistoryShift:
+		return nil
+	}
+	return fmt.Errorf("invalid history policy %q, expected %q, %q or %q",
+		policy, HistoryRefuse, HistoryOrphan, HistoryShift)
+}
+
+// ValidateBackend checks a commit backend name, allowing empty for the default
+func ValidateBackend(backend string) error {
+	switch backend {
+	case "", BackendWorktree, BackendObjects:
+		return nil
+	}
+	return fmt.Errorf("invalid backend %q, expected %q or %q", backend, BackendWorktree, BackendObjects)
+}
+
 func SaveConfig(config *Config, path string) error {
 	data, err := yaml.Marshal(config)
 	if err != nil {
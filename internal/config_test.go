@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigLLMDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		llm     string
+		timeout time.Duration
+		retries int
+	}{
+		{"defaults", "", DefaultLLMTimeout, DefaultLLMRetries},
+		{"configured", "  timeout: 30s\n  retries: 5\n", 30 * time.Second, 5},
+		{"disabled", "  timeout: -1s\n  retries: -1\n", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			data := "llm:\n  provider: offline\n" + tt.llm + "repositories:\n  - path: repo\n"
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+			if config.LLM.Timeout != tt.timeout || config.LLM.Retries != tt.retries {
+				t.Errorf("timeout, retries = %s, %d, want %s, %d", config.LLM.Timeout, config.LLM.Retries, tt.timeout, tt.retries)
+			}
+		})
+	}
+}
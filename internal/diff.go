@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileDiff is the change a commit makes to one file, computed from the
+// file's content before and after
+type FileDiff struct {
+	Path    string
+	From    string // previous path of a renamed file
+	Diff    string // unified diff, empty when only the path changed
+	Added   int
+	Removed int
+}
+
+// NewFileDiff diffs the content of a file before and after a change. New
+// files have no original content, deleted ones no modified content.
+func NewFileDiff(filePath, original, modified string) FileDiff {
+	d := FileDiff{Path: filePath, Diff: UnifiedDiff(filePath, original, modified)}
+	d.Added, d.Removed = DiffStats(d.Diff)
+	return d
+}
+
+// Stat describes the change in a single line of a diffstat
+func (d FileDiff) Stat() string {
+	if d.From != "" {
+		return fmt.Sprintf("%s => %s", d.From, d.Path)
+	}
+	return fmt.Sprintf("%s | +%d -%d", d.Path, d.Added, d.Removed)
+}
+
+// FormatDiffs lists the diffstat of a commit followed by its diffs, leaving
+// out the diffs that no longer fit once maxChars is reached
+func FormatDiffs(diffs []FileDiff, maxChars int) string {
+	var out strings.Builder
+	added, removed := 0, 0
+	for _, d := range diffs {
+		out.WriteString(d.Stat() + "\n")
+		added += d.Added
+		removed += d.Removed
+	}
+	fmt.Fprintf(&out, "%d files changed, %d insertions(+), %d deletions(-)\n", len(diffs), added, removed)
+
+	left := 0
+	for _, d := range diffs {
+		if d.Diff == "" {
+			continue
+		}
+		if maxChars > 0 && out.Len()+len(d.Diff) > maxChars {
+			left++
+			continue
+		}
+		out.WriteString("\n" + d.Diff)
+	}
+	if left > 0 {
+		fmt.Fprintf(&out, "\n(diffs of %d more files left out for length)\n", left)
+	}
+	return out.String()
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// EditPool generates code changes for upcoming commits on a bounded number
+// of workers while earlier commits are still being applied. Edits are
+// matched to the file content they were generated from, so an edit whose
+// file changed in the meantime is simply generated again.
+type EditPool struct {
+	llm    *LLMOperations
+	ctx    context.Context
+	cancel context.CancelFunc
+	jobs   chan *pendingEdit
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[editKey]*pendingEdit
+}
+
+type editKey struct {
+	commit  time.Time // identifies the planned commit the edit is for
+	file    string
+	content [sha256.Size]byte
+}
+
+// pendingEdit is an edit that has been queued, and once done is closed, its
+// result
+type pendingEdit struct {
+	key     editKey
+	pattern CommitPattern
+	content string
+	done    chan struct{}
+	skip    bool // the commit was applied before the edit was started
+
+	edit FileEdit
+	err  error
+}
+
+// NewEditPool starts workers generating edits until ctx is cancelled or the
+// pool is closed. With fewer than two workers nothing is generated ahead of
+// time.
+func NewEditPool(ctx context.Context, llm *LLMOperations, workers int) *EditPool {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &EditPool{
+		llm:     llm,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[editKey]*pendingEdit),
+	}
+	if workers < 2 {
+		return p
+	}
+
+	// A short queue keeps the work done ahead close to the commit being applied
+	p.jobs = make(chan *pendingEdit, workers)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+func (p *EditPool) work() {
+	defer p.wg.Done()
+	for edit := range p.jobs {
+		p.mu.Lock()
+		skip := edit.skip
+		p.mu.Unlock()
+
+		switch {
+		case p.ctx.Err() != nil:
+			edit.err = p.ctx.Err()
+		case !skip:
+			edit.edit, edit.err = p.llm.EditFile(p.ctx, edit.pattern, edit.key.file, edit.content)
+		}
+		close(edit.done)
+	}
+}
+
+func newEditKey(pattern CommitPattern, filePath, content string) editKey {
+	return editKey{commit: pattern.Timestamp, file: filePath, content: sha256.Sum256([]byte(content))}
+}
+
+// Prefetch queues an edit to a file with the given content for a planned
+// commit. It never blocks: edits that are already queued, or do not fit in
+// the queue, are left for later.
+func (p *EditPool) Prefetch(pattern CommitPattern, filePath, content string) {
+	key := newEditKey(pattern, filePath, content)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.jobs == nil {
+		return
+	}
+	if _, ok := p.pending[key]; ok {
+		return
+	}
+
+	edit := &pendingEdit{key: key, pattern: pattern, content: content, done: make(chan struct{})}
+	select {
+	case p.jobs <- edit:
+		p.pending[key] = edit
+	default:
+	}
+}
+
+// EditFile returns the edit generated ahead of time for a file with the
+// given content, waiting for it if it is still being generated, or generates
+// it now if it was never queued
+func (p *EditPool) EditFile(ctx context.Context, pattern CommitPattern, filePath, content string) (FileEdit, error) {
+	key := newEditKey(pattern, filePath, content)
+	p.mu.Lock()
+	edit, ok := p.pending[key]
+	delete(p.pending, key)
+	p.mu.Unlock()
+
+	if !ok {
+		return p.llm.EditFile(ctx, pattern, filePath, content)
+	}
+
+	select {
+	case <-edit.done:
+	case <-ctx.Done():
+		return FileEdit{}, ctx.Err()
+	}
+	return edit.edit, edit.err
+}
+
+// Forget drops the edits generated for a planned commit once it has been
+// applied, since whatever was not used by then never will be
+func (p *EditPool) Forget(pattern CommitPattern) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, edit := range p.pending {
+		if key.commit.Equal(pattern.Timestamp) {
+			edit.skip = true
+			delete(p.pending, key)
+		}
+	}
+}
+
+// Close abandons the edits still being generated and waits for the workers
+// to stop
+func (p *EditPool) Close() {
+	p.cancel()
+
+	p.mu.Lock()
+	if p.jobs != nil {
+		close(p.jobs)
+		p.jobs = nil
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DefaultMaxFileSize is the largest file sent to the LLM unless configured
+const DefaultMaxFileSize = 100 * 1024
+
+// Reasons a file is not eligible for modification
+const (
+	SkipBinary    = "binary"
+	SkipTooLarge  = "too large"
+	SkipGenerated = "generated"
+	SkipMinified  = "minified"
+	SkipVendored  = "vendored"
+	SkipLockfile  = "lockfile"
+)
+
+var (
+	vendorDirs = map[string]bool{
+		"vendor":           true,
+		"node_modules":     true,
+		"third_party":      true,
+		"bower_components": true,
+	}
+
+	lockfiles = map[string]bool{
+		"package-lock.json": true,
+		"yarn.lock":         true,
+		"pnpm-lock.yaml":    true,
+		"go.sum":            true,
+		"Cargo.lock":        true,
+		"Gemfile.lock":      true,
+		"poetry.lock":       true,
+		"Pipfile.lock":      true,
+		"composer.lock":     true,
+		"uv.lock":           true,
+	}
+
+	// The standard Go marker, https://go.dev/s/generatedcode, and the
+	// @generated tag other tools put at the start of a comment
+	generatedMarker = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$|^\s*(//|#|--|/\*+|\*)\s*@generated\b`)
+
+	// proseExtensions are files whose paragraphs may be a single long line
+	proseExtensions = map[string]bool{
+		".md":       true,
+		".markdown": true,
+		".txt":      true,
+		".rst":      true,
+		".adoc":     true,
+	}
+)
+
+// minifiedLineLength is the line length beyond which a file is taken to be
+// minified
+const minifiedLineLength = 1000
+
+// SkippedFile is a matching file that was left out and why
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// FileEligibility decides whether a file is safe to hand to the LLM
+type FileEligibility struct {
+	maxFileSize    int64
+	allowVendored  bool
+	allowGenerated bool
+}
+
+// NewFileEligibility creates the eligibility rules configured for a repository
+func NewFileEligibility(repo Repository) *FileEligibility {
+	maxSize := repo.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+
+	return &FileEligibility{
+		maxFileSize:    maxSize,
+		allowVendored:  repo.AllowVendored,
+		allowGenerated: repo.AllowGenerated,
+	}
+}
+
+// CheckPath applies the rules that only need the file's path relative to the
+// repository root. It returns an empty reason for eligible files.
+func (e *FileEligibility) CheckPath(relPath string) string {
+	name := filepath.Base(relPath)
+
+	if !e.allowVendored {
+		for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+			if vendorDirs[dir] {
+				return SkipVendored
+			}
+		}
+		if lockfiles[name] {
+			return SkipLockfile
+		}
+	}
+
+	if !e.allowGenerated && (strings.Contains(name, ".min.") || strings.HasSuffix(name, ".bundle.js")) {
+		return SkipMinified
+	}
+
+	return ""
+}
+
+// CheckFile applies every rule to a file on disk
+func (e *FileEligibility) CheckFile(relPath, fullPath string) (string, error) {
+	if reason := e.CheckPath(relPath); reason != "" {
+		return reason, nil
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	if info.Size() > e.maxFileSize {
+		return SkipTooLarge, nil
+	}
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", relPath, err)
+	}
+	defer f.Close()
+
+	// Binary content, generated markers and minified lines all show up near
+	// the top of a file
+	head := make([]byte, 8000)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read %s: %w", relPath, err)
+	}
+
+	return e.checkHead(relPath, head[:n]), nil
+}
+
+// CheckContent applies every rule to content already read into memory
+func (e *FileEligibility) CheckContent(relPath, content string) string {
+	if reason := e.CheckPath(relPath); reason != "" {
+		return reason
+	}
+	if int64(len(content)) > e.maxFileSize {
+		return SkipTooLarge
+	}
+
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	return e.checkHead(relPath, []byte(head))
+}
+
+func (e *FileEligibility) checkHead(relPath string, head []byte) string {
+	// Same heuristic git uses to decide whether to diff a file
+	if bytes.IndexByte(head, 0) >= 0 {
+		return SkipBinary
+	}
+
+	if e.allowGenerated {
+		return ""
+	}
+
+	prose := proseExtensions[strings.ToLower(filepath.Ext(relPath))]
+	for _, line := range strings.Split(string(head), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if generatedMarker.MatchString(line) {
+			return SkipGenerated
+		}
+		if !prose && len(line) > minifiedLineLength {
+			return SkipMinified
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckContent(t *testing.T) {
+	longLine := strings.Repeat("x", minifiedLineLength+1)
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		want    string
+	}{
+		{"plain source", "main.go", "package main\n", ""},
+		{"go generated marker", "api.pb.go", "// Code generated by protoc-gen-go. DO NOT EDIT.\npackage api\n", SkipGenerated},
+		{"go marker must be the whole line", "main.go", "package main\n\n// Code generated files say DO NOT EDIT. at the top\n", ""},
+		{"@generated line comment", "schema.ts", "// @generated by relay-compiler\nexport {}\n", SkipGenerated},
+		{"@generated hash comment", "models.py", "# @generated\nimport os\n", SkipGenerated},
+		{"@generated block comment", "Schema.java", "/**\n * @generated SignedSource<<abc>>\n */\nclass Schema {}\n", SkipGenerated},
+		{"@generated mentioned in a string", "check.py", "MARKER = \"@generated\"\n", ""},
+		{"@generated mentioned in prose", "check.go", "// Files tagged @generated are skipped\npackage check\n", ""},
+		{"minified line", "app.js", "var a=1;" + longLine + "\n", SkipMinified},
+		{"long paragraph in markdown", "README.md", longLine + "\n", ""},
+		{"binary", "image.go", "GIF89a\x00\x01", SkipBinary},
+		{"vendored", "vendor/lib/lib.go", "package lib\n", SkipVendored},
+		{"lockfile", "go.sum", "example.com/x v1.0.0 h1:abc=\n", SkipLockfile},
+		{"minified by name", "static/app.min.js", "var a=1;\n", SkipMinified},
+	}
+
+	eligibility := NewFileEligibility(Repository{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eligibility.CheckContent(tt.path, tt.content); got != tt.want {
+				t.Errorf("CheckContent(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -36,7 +36,7 @@ func NewFileModifier() *FileModifier {
 			".py": regexp.MustCompile(`def\s+([a-zA-Z_][a-zA-Z0-9_]*)\s*\([^)]*\):`),
 			".js": regexp.MustCompile(`(function\s+[a-zA-Z_][a-zA-Z0-9_]*|\w+\s*=\s*function)\s*\([^)]*\)`),
 			".ts": regexp.MustCompile(`(function\s+[a-zA-Z_][a-zA-Z0-9_]*|\w+\s*=\s*function|\w+\s*:\s*\([^)]*\)\s*=>)`),
-			".go": regexp.MustCompile(`func\s+([a-zA-Z_][a-zA-Z0-9_]*)\s*\([^)]*\)`),
+			".go": regexp.MustCompile(`func\s+(?:\([^)]*\)\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\(`),
 		},
 	}
 }
@@ -73,7 +73,7 @@ func (f *FileModifier) PrepareFileContent(filePath string, content string) (*Fil
 
 	// Find functions
 	if pattern, ok := f.funcPatterns[ext]; ok {
-		metadata.Functions = f.findFunctions(lines, pattern)
+		metadata.Functions = f.findFunctions(lines, pattern, ext != ".py")
 	}
 
 	// Find comments
@@ -116,17 +116,27 @@ func (f *FileModifier) SuggestChanges(filePath string, metadata *FileMetadata) [
 	return changes
 }
 
-func (f *FileModifier) findFunctions(lines []string, pattern *regexp.Regexp) []FunctionInfo {
+// findFunctions finds the functions declared in lines. Functions in brace
+// languages end where their braces balance, others where the indentation
+// returns to that of the declaration. EndLine is exclusive.
+func (f *FileModifier) findFunctions(lines []string, pattern *regexp.Regexp, braces bool) []FunctionInfo {
 	var functions []FunctionInfo
 
 	for i, line := range lines {
 		if match := pattern.FindStringSubmatch(line); match != nil {
-			// Find function end (basic implementation)
 			end := i + 1
-			indent := len(line) - len(strings.TrimLeft(line, " \t"))
-			for end < len(lines) && (len(lines[end]) == 0 ||
-				len(strings.TrimLeft(lines[end], " \t")) > indent) {
-				end++
+			if braces {
+				end = braceBlockEnd(lines, i)
+			} else {
+				indent := len(line) - len(strings.TrimLeft(line, " \t"))
+				for end < len(lines) && (len(lines[end]) == 0 ||
+					len(lines[end])-len(strings.TrimLeft(lines[end], " \t")) > indent) {
+					end++
+				}
+				// Trailing blank lines belong to whatever comes next
+				for end > i+1 && strings.TrimSpace(lines[end-1]) == "" {
+					end--
+				}
 			}
 
 			functions = append(functions, FunctionInfo{
@@ -141,6 +151,28 @@ func (f *FileModifier) findFunctions(lines []string, pattern *regexp.Regexp) []F
 	return functions
 }
 
+// braceBlockEnd returns the line after the one where the braces opened at or
+// after start are closed again. Braces in strings and comments are counted
+// too, which is close enough to find a function.
+func braceBlockEnd(lines []string, start int) int {
+	depth, opened := 0, false
+	for i := start; i < len(lines); i++ {
+		for _, r := range lines[i] {
+			switch r {
+			case '{':
+				depth++
+				opened = true
+			case '}':
+				depth--
+			}
+		}
+		if opened && depth <= 0 {
+			return i + 1
+		}
+	}
+	return len(lines)
+}
+
 func (f *FileModifier) findComments(lines []string, commentStyle string) [][2]int {
 	var comments [][2]int
 	var currentBlock *[2]int
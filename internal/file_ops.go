@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// File operations a commit can perform besides modifying existing files
+const (
+	FileOpAdd    = "add"
+	FileOpDelete = "delete"
+	FileOpRename = "rename"
+)
+
+// changeFileOperations maps change types to the file operation they perform
+var changeFileOperations = map[string]string{
+	"add_module":       FileOpAdd,
+	"add_test_file":    FileOpAdd,
+	"add_docs":         FileOpAdd,
+	"remove_dead_file": FileOpDelete,
+	"rename_file":      FileOpRename,
+}
+
+var (
+	moduleNames = []string{"helpers", "client", "handlers", "validation", "cache", "models", "service", "middleware", "formatters", "events"}
+	docNames    = []string{"architecture", "usage", "configuration", "troubleshooting", "deployment", "development"}
+	moveDirs    = []string{"core", "common", "internal", "lib"}
+)
+
+// FileOperation returns the file operation a change type performs, or an
+// empty string when it only modifies existing files
+func FileOperation(changeType string) string {
+	return changeFileOperations[changeType]
+}
+
+// NewFile is a file a commit creates
+type NewFile struct {
+	Path     string `json:"path"`
+	Template string `json:"template,omitempty"` // existing file whose style the new file follows
+}
+
+// FileRename moves a file, keeping its content
+type FileRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FilePlanner decides which files commits create, delete and rename while a
+// plan is built, keeping track of the files that exist after each commit.
+// Deletes and renames only touch files created earlier in the plan unless the
+// repository allows restructuring existing files.
+type FilePlanner struct {
+	repo    Repository
+	files   []string        // files commits may modify
+	exists  map[string]bool // every known path, including files that do not match the patterns
+	created map[string]bool
+}
+
+// NewFilePlanner starts planning from the repository's modifiable files
+func NewFilePlanner(repo Repository, files []string) *FilePlanner {
+	p := &FilePlanner{
+		repo:    repo,
+		exists:  make(map[string]bool),
+		created: make(map[string]bool),
+	}
+	for _, file := range files {
+		p.exists[file] = true
+		p.files = append(p.files, file)
+	}
+	return p
+}
+
+// Plan returns the commit for a pattern with the file operations its change
+// type calls for. Files to modify are left for the caller to pick from
+// Available.
+func (p *FilePlanner) Plan(pattern CommitPattern) PlannedCommit {
+	planned := PlannedCommit{Pattern: pattern}
+	if len(p.files) == 0 {
+		return planned
+	}
+
+	switch FileOperation(pattern.ChangeType) {
+	case FileOpAdd:
+		if template := p.pickTemplate(pattern.ChangeType); template != "" {
+			newPath := p.newFilePath(pattern.ChangeType, template)
+			planned.Added = []NewFile{{Path: newPath, Template: template}}
+			p.add(newPath)
+		}
+	case FileOpDelete:
+		if target := p.pickRestructurable(); target != "" {
+			planned.Deleted = []string{target}
+			p.remove(target)
+		}
+	case FileOpRename:
+		if target := p.pickRestructurable(); target != "" {
+			to := p.renamePath(target)
+			planned.Renamed = []FileRename{{From: target, To: to}}
+			p.remove(target)
+			p.add(to)
+		}
+	}
+
+	return planned
+}
+
+// Available returns the files a planned commit may still modify: every file
+// that exists and is not already part of the commit
+func (p *FilePlanner) Available(planned PlannedCommit) []string {
+	touched := make(map[string]bool)
+	for _, file := range planned.Paths() {
+		touched[file] = true
+	}
+
+	var files []string
+	for _, file := range p.files {
+		if !touched[file] {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// add records a file created during the plan. Only files matching the
+// repository's patterns become candidates for later commits.
+func (p *FilePlanner) add(file string) {
+	p.exists[file] = true
+	if matchesAny(p.repo.Patterns, filepath.ToSlash(file)) {
+		p.files = append(p.files, file)
+		p.created[file] = true
+	}
+}
+
+func (p *FilePlanner) remove(file string) {
+	delete(p.exists, file)
+	delete(p.created, file)
+	for i, existing := range p.files {
+		if existing == file {
+			p.files = append(p.files[:i], p.files[i+1:]...)
+			break
+		}
+	}
+}
+
+// pickTemplate picks the existing file a new file is modeled on, or returns
+// an empty string when there is none suitable. Modules and tests follow
+// source files that are not tests themselves, and tests are only written for
+// files that have none yet.
+func (p *FilePlanner) pickTemplate(changeType string) string {
+	candidates := p.files
+	if changeType == "add_module" || changeType == "add_test_file" {
+		candidates = nil
+		for _, file := range p.files {
+			if !isSourceFile(file) || isTestFile(file) {
+				continue
+			}
+			if changeType == "add_test_file" && p.exists[testPath(file)] {
+				continue
+			}
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// pickRestructurable picks a file that may be deleted or renamed
+func (p *FilePlanner) pickRestructurable() string {
+	var candidates []string
+	for _, file := range p.files {
+		if p.created[file] || p.repo.RestructureExisting {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// newFilePath names a file created next to template
+func (p *FilePlanner) newFilePath(changeType, template string) string {
+	dir, ext := filepath.Dir(template), filepath.Ext(template)
+
+	switch changeType {
+	case "add_test_file":
+		return p.unique(testPath(template))
+	case "add_docs":
+		return p.unique(filepath.Join("docs", docNames[rand.Intn(len(docNames))]+".md"))
+	default:
+		return p.unique(filepath.Join(dir, moduleNames[rand.Intn(len(moduleNames))]+ext))
+	}
+}
+
+// renamePath either gives a file a new name or moves it into a subdirectory
+func (p *FilePlanner) renamePath(file string) string {
+	dir, base := filepath.Dir(file), filepath.Base(file)
+	if rand.Intn(2) == 0 && !isMoveDir(filepath.Base(dir)) {
+		return p.unique(filepath.Join(dir, moveDirs[rand.Intn(len(moveDirs))], base))
+	}
+	return p.unique(filepath.Join(dir, moduleNames[rand.Intn(len(moduleNames))]+filepath.Ext(file)))
+}
+
+// isMoveDir reports whether dir is one files get moved into, so files are
+// not moved down one level after another
+func isMoveDir(dir string) bool {
+	for _, moveDir := range moveDirs {
+		if dir == moveDir {
+			return true
+		}
+	}
+	return false
+}
+
+// unique numbers a path until it does not clash with an existing file
+func (p *FilePlanner) unique(file string) string {
+	ext := filepath.Ext(file)
+	stem := strings.TrimSuffix(file, ext)
+	candidate := file
+	for i := 2; p.exists[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d%s", stem, i, ext)
+	}
+	return candidate
+}
+
+// testPath returns the conventional test file name for a source file
+func testPath(file string) string {
+	dir, ext := filepath.Dir(file), filepath.Ext(file)
+	stem := strings.TrimSuffix(filepath.Base(file), ext)
+
+	switch ext {
+	case ".py":
+		return filepath.Join(dir, "test_"+stem+ext)
+	case ".js", ".jsx", ".ts", ".tsx":
+		return filepath.Join(dir, stem+".test"+ext)
+	default:
+		return filepath.Join(dir, stem+"_test"+ext)
+	}
+}
+
+// testFileStem matches the names of test files, including ones numbered to
+// keep them unique
+var testFileStem = regexp.MustCompile(`^test_|(_test|\.test)(_\d+)?$`)
+
+func isTestFile(file string) bool {
+	base := path.Base(filepath.ToSlash(file))
+	return testFileStem.MatchString(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+// nonCodeLanguages are the known languages of files that hold documentation,
+// data or styles rather than code
+var nonCodeLanguages = map[string]bool{
+	"Markdown": true, "YAML": true, "JSON": true, "TOML": true,
+	"HTML": true, "CSS": true, "SCSS": true,
+}
+
+// isSourceFile reports whether a file holds code in a known language
+func isSourceFile(file string) bool {
+	language := Language(file)
+	return language != "" && !nonCodeLanguages[language]
+}
@@ -2,112 +2,651 @@ package internal
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 )
 
 // GitOperations handles all git-related functionality
 type GitOperations struct {
-	repo     *git.Repository
-	repoPath string
+	repo         *git.Repository
+	repoPath     string
+	bare         bool
+	originalHead *plumbing.Reference
+	objects      *objectState            // set when commits are built in the object store
+	snapshots    map[string]fileSnapshot // files changed since the last commit
 }
 
-// NewGitOperations creates a new GitOperations instance
+// NewGitOperations creates a new GitOperations instance for a repository
+// without uncommitted changes
 func NewGitOperations(repoPath string) (*GitOperations, error) {
-	repo, err := git.PlainOpen(repoPath)
+	g, err := OpenRepository(repoPath)
 	if err != nil {
-		return nil, fmt.Errorf("invalid git repository: %w", err)
+		return nil, err
 	}
 
-	// Check if repo has uncommitted changes
-	w, err := repo.Worktree()
+	clean, err := g.IsClean()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get worktree: %w", err)
+		return nil, err
+	}
+	if !clean {
+		return nil, fmt.Errorf("repository has uncommitted changes: %s", repoPath)
 	}
 
-	status, err := w.Status()
+	return g, nil
+}
+
+// OpenRepository creates a GitOperations instance whatever the state of the
+// repository's worktree
+func OpenRepository(repoPath string) (*GitOperations, error) {
+	repo, err := git.PlainOpen(repoPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get status: %w", err)
+		return nil, fmt.Errorf("invalid git repository: %w", err)
 	}
 
-	if !status.IsClean() {
-		return nil, fmt.Errorf("repository has uncommitted changes: %s", repoPath)
+	_, err = repo.Worktree()
+	if err != nil && err != git.ErrIsBareRepository {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
 	}
 
 	return &GitOperations{
 		repo:     repo,
 		repoPath: repoPath,
+		bare:     err == git.ErrIsBareRepository,
 	}, nil
 }
 
-// GetModifiableFiles returns a list of files matching the given patterns
-func (g *GitOperations) GetModifiableFiles(patterns []string) ([]string, error) {
+// IsClean reports whether the worktree has no uncommitted changes. Bare
+// repositories are always clean.
+func (g *GitOperations) IsClean() (bool, error) {
+	if g.bare {
+		return true, nil
+	}
+
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return false, fmt.Errorf("failed to get status: %w", err)
+	}
+
+	return status.IsClean(), nil
+}
+
+// DiscardChanges resets the worktree and index to HEAD and removes any of
+// paths that HEAD does not have. It cleans up after a commit or checkout that
+// was interrupted halfway.
+func DiscardChanges(repoPath string, paths []string) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid git repository: %w", err)
+	}
+
+	w, err := repo.Worktree()
+	if err == git.ErrIsBareRepository {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// An interrupted write can leave the index truncated, and the reset below
+	// rebuilds it anyway
+	if _, err := repo.Storer.Index(); err != nil {
+		if err := os.Remove(filepath.Join(repoPath, git.GitDirName, "index")); err != nil {
+			return fmt.Errorf("failed to remove unreadable index: %w", err)
+		}
+	}
+
+	// An unborn branch has nothing to reset to
+	var tree *object.Tree
+	head, err := repo.Head()
+	switch {
+	case err == nil:
+		if err := w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+			return fmt.Errorf("failed to reset worktree: %w", err)
+		}
+		commit, err := repo.CommitObject(head.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		if tree, err = commit.Tree(); err != nil {
+			return fmt.Errorf("failed to read HEAD tree: %w", err)
+		}
+	case err != plumbing.ErrReferenceNotFound:
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	for _, path := range paths {
+		if tree != nil {
+			if _, err := tree.File(filepath.ToSlash(path)); err == nil {
+				continue
+			}
+		}
+		if err := os.Remove(filepath.Join(repoPath, path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// RestoreRunRefs moves branches back to the commits a run last recorded on
+// them and deletes tags the run did not record. Only refs that are unreadable
+// or point at the run's own unrecorded work are touched. It returns the refs
+// it changed.
+func RestoreRunRefs(repoPath, runID string, tips map[string]string, tags []string) ([]string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git repository: %w", err)
+	}
+
+	var restored []string
+	for branch, tip := range tips {
+		refName := plumbing.HEAD
+		if branch != "" {
+			refName = plumbing.NewBranchReferenceName(branch)
+		}
+
+		// An interrupted write can leave a ref empty, which reads as a zero hash
+		ref, err := repo.Reference(refName, true)
+		if err == nil && !ref.Hash().IsZero() {
+			if ref.Hash().String() == tip {
+				continue
+			}
+			commit, err := repo.CommitObject(ref.Hash())
+			if err == nil && !HasRunTrailer(commit.Message, runID) {
+				continue
+			}
+		}
+
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(refName, plumbing.NewHash(tip))); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", refName, err)
+		}
+		restored = append(restored, refName.String())
+	}
+
+	recorded := make(map[string]bool)
+	for _, tag := range tags {
+		recorded[tag] = true
+	}
+
+	iter, err := repo.Tags()
+	if err != nil {
+		return restored, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer iter.Close()
+
+	var unrecorded []plumbing.ReferenceName
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if recorded[ref.Name().Short()] {
+			return nil
+		}
+		tag, err := repo.TagObject(ref.Hash())
+		if err == nil && HasRunTrailer(tag.Message, runID) {
+			unrecorded = append(unrecorded, ref.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return restored, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	for _, name := range unrecorded {
+		if err := repo.Storer.RemoveReference(name); err != nil {
+			return restored, fmt.Errorf("failed to delete %s: %w", name, err)
+		}
+		restored = append(restored, name.String())
+	}
+
+	return restored, nil
+}
+
+// GetModifiableFiles returns the files matching the repository's patterns
+// that are eligible to be modified
+func (g *GitOperations) GetModifiableFiles(repo Repository) ([]string, error) {
+	files, _, err := g.ScanFiles(repo)
+	return files, err
+}
+
+// ScanFiles returns the files matching the repository's patterns and not its
+// exclude rules, split into eligible files and skipped files with reasons.
+// Patterns without a slash match a file name in any directory, others match
+// the path from the repository root and support ** for any number of
+// directories. Untracked files ignored by .gitignore are never matched.
+func (g *GitOperations) ScanFiles(repo Repository) ([]string, []SkippedFile, error) {
+	for _, pattern := range append(append([]string{}, repo.Patterns...), repo.Exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, nil, fmt.Errorf("invalid pattern %s", pattern)
+		}
+	}
+
+	var candidates []string
+	var err error
+	if g.objects != nil {
+		// The object store only knows about committed files
+		candidates, err = g.objectFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		tracked, err := g.trackedFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if repo.TrackedOnly {
+			for file := range tracked {
+				candidates = append(candidates, file)
+			}
+		} else {
+			candidates, err = g.worktreeFiles(tracked)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	sort.Strings(candidates)
+
+	eligibility := NewFileEligibility(repo)
+
 	var files []string
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(filepath.Join(g.repoPath, "**", pattern))
+	var skipped []SkippedFile
+	for _, file := range candidates {
+		if !matchesAny(repo.Patterns, file) || matchesAny(repo.Exclude, file) {
+			continue
+		}
+
+		relPath := filepath.FromSlash(file)
+		reason, err := g.checkEligibility(eligibility, relPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reason != "" {
+			skipped = append(skipped, SkippedFile{Path: relPath, Reason: reason})
+			continue
+		}
+		files = append(files, relPath)
+	}
+
+	return files, skipped, nil
+}
+
+// checkEligibility applies the eligibility rules to a file in the worktree or,
+// for the object backend, in the tree being built
+func (g *GitOperations) checkEligibility(eligibility *FileEligibility, relPath string) (string, error) {
+	if g.objects == nil {
+		return eligibility.CheckFile(relPath, filepath.Join(g.repoPath, relPath))
+	}
+
+	if reason := eligibility.CheckPath(relPath); reason != "" {
+		return reason, nil
+	}
+	content, err := g.objectReadFile(relPath)
+	if err != nil {
+		return "", err
+	}
+	return eligibility.CheckContent(relPath, content), nil
+}
+
+// trackedFiles returns the slash-separated paths in the index
+func (g *GitOperations) trackedFiles() (map[string]bool, error) {
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	tracked := make(map[string]bool, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		tracked[entry.Name] = true
+	}
+	return tracked, nil
+}
+
+// worktreeFiles walks the worktree and returns the slash-separated paths of
+// regular files that are tracked or not ignored
+func (g *GitOperations) worktreeFiles(tracked map[string]bool) ([]string, error) {
+	ignorePatterns, err := gitignore.ReadPatterns(osfs.New(g.repoPath), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+	ignored := gitignore.NewMatcher(ignorePatterns)
+
+	var files []string
+	err = filepath.WalkDir(g.repoPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return nil, fmt.Errorf("failed to glob pattern %s: %w", pattern, err)
-		}
-		for _, match := range matches {
-			// Skip git directory and empty directories
-			if !isGitPath(match) && isRegularFile(match) {
-				relPath, err := filepath.Rel(g.repoPath, match)
-				if err == nil {
-					files = append(files, relPath)
-				}
+			return err
+		}
+
+		rel, err := filepath.Rel(g.repoPath, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		parts := strings.Split(rel, "/")
+
+		if d.IsDir() {
+			if d.Name() == git.GitDirName || ignored.Match(parts, true) {
+				return filepath.SkipDir
 			}
+			return nil
 		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if !tracked[rel] && ignored.Match(parts, false) {
+			return nil
+		}
+
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk repository: %w", err)
 	}
+
 	return files, nil
 }
 
 // CreateCommit creates a new commit with the given message and files
-func (g *GitOperations) CreateCommit(message string, filesToModify []string, timestamp *time.Time) error {
+func (g *GitOperations) CreateCommit(message string, filesToModify []string, timestamp *time.Time) (plumbing.Hash, error) {
+	if g.objects != nil {
+		return g.objectCreateCommit(message, filesToModify, timestamp)
+	}
+	if g.bare {
+		return plumbing.ZeroHash, errBareWorktree
+	}
+
 	w, err := g.repo.Worktree()
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+		return plumbing.ZeroHash, fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	// Stage modified files
+	// Stage modified files, and removals of files that no longer exist
 	for _, file := range filesToModify {
+		if _, err := os.Lstat(filepath.Join(g.repoPath, file)); os.IsNotExist(err) {
+			if _, err := w.Remove(file); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("failed to stage removal of %s: %w", file, err)
+			}
+			continue
+		}
+
 		_, err := w.Add(file)
 		if err != nil {
-			return fmt.Errorf("failed to stage file %s: %w", file, err)
+			return plumbing.ZeroHash, fmt.Errorf("failed to stage file %s: %w", file, err)
 		}
 	}
 
 	// Create commit options
+	author := devMetricsSignature(time.Now())
 	opts := &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "Dev Metrics",
-			Email: "dev@metrics.local",
-			When:  time.Now(),
-		},
+		Author: &author,
 	}
 
 	// Set custom timestamp if provided
 	if timestamp != nil {
+		committer := devMetricsSignature(*timestamp)
 		opts.Author.When = *timestamp
-		opts.Committer = &object.Signature{
-			Name:  "Dev Metrics",
-			Email: "dev@metrics.local",
-			When:  *timestamp,
-		}
+		opts.Committer = &committer
 	}
 
 	// Create commit
-	_, err = w.Commit(message, opts)
+	hash, err := w.Commit(message, opts)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	// The changes are committed, so there is nothing left to roll back
+	g.snapshots = nil
+
+	return hash, nil
+}
+
+// Head returns the checked out branch (empty when HEAD is detached) and the
+// commit it points to
+func (g *GitOperations) Head() (string, plumbing.Hash, error) {
+	if g.objects != nil {
+		branch, hash := g.objectHead()
+		return branch, hash, nil
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return "", plumbing.ZeroHash, fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	if !head.Name().IsBranch() {
+		return "", head.Hash(), nil
+	}
+	return head.Name().Short(), head.Hash(), nil
+}
+
+// CheckoutBranch switches the worktree to branch, creating it from baseRef
+// (or the current HEAD when baseRef is empty) if it does not exist yet. It
+// reports whether the branch was created.
+func (g *GitOperations) CheckoutBranch(branch, baseRef string) (bool, error) {
+	if g.objects != nil {
+		return g.objectCheckoutBranch(branch, baseRef)
+	}
+	if g.bare {
+		return false, errBareWorktree
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return false, fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	opts := &git.CheckoutOptions{Branch: branchRef}
+
+	ref, err := g.repo.Reference(branchRef, true)
+	switch {
+	// An empty ref is all that is left when creating the branch was interrupted
+	case err == plumbing.ErrReferenceNotFound, err == nil && ref.Hash().IsZero():
+		if err == nil {
+			if err := g.repo.Storer.RemoveReference(branchRef); err != nil {
+				return false, fmt.Errorf("failed to remove empty branch %s: %w", branch, err)
+			}
+		}
+		base := head.Hash()
+		if baseRef != "" {
+			hash, err := g.repo.ResolveRevision(plumbing.Revision(baseRef))
+			if err != nil {
+				return false, fmt.Errorf("failed to resolve base ref %s: %w", baseRef, err)
+			}
+			base = *hash
+		}
+		opts.Create = true
+		opts.Hash = base
+	case err != nil:
+		return false, fmt.Errorf("failed to read branch %s: %w", branch, err)
+	}
+
+	if err := w.Checkout(opts); err != nil {
+		return false, fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+	}
+
+	if g.originalHead == nil {
+		g.originalHead = head
+	}
+
+	return opts.Create, nil
+}
+
+// CheckoutOrphan points HEAD at a new branch with no commits so the next
+// commit starts an unrelated history. The index and worktree are kept, so the
+// first commit contains the current tree.
+func (g *GitOperations) CheckoutOrphan(branch string) error {
+	if g.objects != nil {
+		return g.objectCheckoutOrphan(branch)
+	}
+	if g.bare {
+		return errBareWorktree
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if _, err := g.repo.Reference(branchRef, false); err == nil {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return fmt.Errorf("failed to create orphan branch %s: %w", branch, err)
+	}
+
+	if g.originalHead == nil {
+		g.originalHead = head
+	}
+
+	return nil
+}
+
+// CommitTime returns the committer date of the commit rev resolves to
+func (g *GitOperations) CommitTime(rev string) (time.Time, error) {
+	hash, err := g.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+
+	commit, err := g.repo.CommitObject(*hash)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+
+	return commit.Committer.When, nil
+}
+
+// RestoreCheckout switches the worktree back to whatever was checked out
+// before CheckoutBranch was called
+func (g *GitOperations) RestoreCheckout() error {
+	if g.originalHead == nil {
+		return nil
+	}
+
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	opts := &git.CheckoutOptions{Hash: g.originalHead.Hash()}
+	if g.originalHead.Name().IsBranch() {
+		opts = &git.CheckoutOptions{Branch: g.originalHead.Name()}
+	}
+
+	if err := w.Checkout(opts); err != nil {
+		return fmt.Errorf("failed to restore checkout of %s: %w", g.originalHead.Name().Short(), err)
+	}
+
+	g.originalHead = nil
+	return nil
+}
+
+// HasBranch reports whether a local branch exists. An empty ref left behind
+// by an interrupted branch creation does not count.
+func (g *GitOperations) HasBranch(branch string) bool {
+	ref, err := g.repo.Reference(plumbing.NewBranchReferenceName(branch), false)
+	return err == nil && !ref.Hash().IsZero()
+}
+
+// ResetBranch points branch back at hash. When the branch is checked out (or
+// branch is empty and HEAD is detached) the worktree is hard reset as well.
+func (g *GitOperations) ResetBranch(branch string, hash plumbing.Hash) error {
+	current, _, err := g.Head()
+	if err != nil {
+		return err
+	}
+
+	if branch == current && !g.bare {
+		w, err := g.repo.Worktree()
+		if err != nil {
+			return fmt.Errorf("failed to get worktree: %w", err)
+		}
+		if err := w.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+			return fmt.Errorf("failed to reset to %s: %w", hash, err)
+		}
+		return nil
+	}
+
+	if branch == "" && current != "" {
+		return fmt.Errorf("HEAD is no longer detached, refusing to reset it")
+	}
+
+	refName := plumbing.NewBranchReferenceName(branch)
+	if branch == "" {
+		refName = plumbing.HEAD
+	}
+	ref := plumbing.NewHashReference(refName, hash)
+	if err := g.repo.Storer.SetReference(ref); err != nil {
+		return fmt.Errorf("failed to reset branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// DeleteBranch removes a branch that is not currently checked out
+func (g *GitOperations) DeleteBranch(branch string) error {
+	current, _, err := g.Head()
 	if err != nil {
-		return fmt.Errorf("failed to create commit: %w", err)
+		return err
+	}
+	if branch == current {
+		return fmt.Errorf("cannot delete checked out branch %s", branch)
 	}
 
+	if err := g.repo.Storer.RemoveReference(plumbing.NewBranchReferenceName(branch)); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+	}
 	return nil
 }
 
+// BranchTip returns the commit a branch currently points to, or HEAD's
+// commit when branch is empty
+func (g *GitOperations) BranchTip(branch string) (plumbing.Hash, error) {
+	if branch == "" {
+		_, hash, err := g.Head()
+		return hash, err
+	}
+
+	if g.objects != nil {
+		tip, err := g.objectTipOf(plumbing.NewBranchReferenceName(branch))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to read branch %s: %w", branch, err)
+		}
+		return tip.parent, nil
+	}
+
+	ref, err := g.repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read branch %s: %w", branch, err)
+	}
+	return ref.Hash(), nil
+}
+
 // VerifyRepoAccess checks if we have proper access to the repository
 func (g *GitOperations) VerifyRepoAccess() error {
 	// Check if we can read the repo
@@ -116,13 +655,28 @@ func (g *GitOperations) VerifyRepoAccess() error {
 		return fmt.Errorf("failed to read repository head: %w", err)
 	}
 
-	// Try to create and delete a test file
-	testFile := filepath.Join(g.repoPath, ".git_ops_test")
-	if err := os.WriteFile(testFile, []byte("test"), 0644); err != nil {
-		return fmt.Errorf("failed to write test file: %w", err)
+	return g.CheckWritable()
+}
+
+// CheckWritable checks that the directories commits are written to can be
+// written without creating anything in them
+func (g *GitOperations) CheckWritable() error {
+	var dirs []string
+	if !g.bare {
+		dirs = append(dirs, g.repoPath)
+	}
+
+	// The git directory may live outside the worktree, as in linked worktrees
+	gitDir := filepath.Join(g.repoPath, git.GitDirName)
+	if storage, ok := g.repo.Storer.(*filesystem.Storage); ok {
+		gitDir = storage.Filesystem().Root()
 	}
-	if err := os.Remove(testFile); err != nil {
-		return fmt.Errorf("failed to remove test file: %w", err)
+	dirs = append(dirs, gitDir, filepath.Join(gitDir, "objects"), filepath.Join(gitDir, "refs"))
+
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			return fmt.Errorf("%s is not writable: %w", dir, err)
+		}
 	}
 
 	return nil
@@ -130,7 +684,19 @@ func (g *GitOperations) VerifyRepoAccess() error {
 
 // ModifyFile modifies a file with new content
 func (g *GitOperations) ModifyFile(filePath string, newContent string) error {
+	if g.objects != nil {
+		g.objectModifyFile(filePath, newContent)
+		return nil
+	}
+
+	if err := g.snapshot(filePath); err != nil {
+		return err
+	}
+
 	fullPath := filepath.Join(g.repoPath, filePath)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", filePath, err)
+	}
 	err := os.WriteFile(fullPath, []byte(newContent), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to modify file %s: %w", filePath, err)
@@ -138,8 +704,61 @@ func (g *GitOperations) ModifyFile(filePath string, newContent string) error {
 	return nil
 }
 
+// DeleteFile removes a file. The removal is staged by CreateCommit.
+func (g *GitOperations) DeleteFile(filePath string) error {
+	if g.objects != nil {
+		if _, err := g.objectReadFile(filePath); err != nil {
+			return err
+		}
+		g.objectDeleteFile(filePath)
+		return nil
+	}
+
+	if err := g.snapshot(filePath); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(g.repoPath, filePath)); err != nil {
+		return fmt.Errorf("failed to delete file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// RenameFile moves a file, creating directories as needed. Both paths must
+// be passed to CreateCommit.
+func (g *GitOperations) RenameFile(from, to string) error {
+	if g.objects != nil {
+		content, err := g.objectReadFile(from)
+		if err != nil {
+			return err
+		}
+		g.objectModifyFile(to, content)
+		g.objectDeleteFile(from)
+		return nil
+	}
+
+	if err := g.snapshot(from); err != nil {
+		return err
+	}
+	if err := g.snapshot(to); err != nil {
+		return err
+	}
+
+	toPath := filepath.Join(g.repoPath, to)
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", to, err)
+	}
+	if err := os.Rename(filepath.Join(g.repoPath, from), toPath); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
 // ReadFile reads the contents of a file
 func (g *GitOperations) ReadFile(filePath string) (string, error) {
+	if g.objects != nil {
+		return g.objectReadFile(filePath)
+	}
+
 	fullPath := filepath.Join(g.repoPath, filePath)
 	content, err := os.ReadFile(fullPath)
 	if err != nil {
@@ -148,16 +767,64 @@ func (g *GitOperations) ReadFile(filePath string) (string, error) {
 	return string(content), nil
 }
 
+// ExpandBranchName replaces the {date} placeholder in a branch name
+func ExpandBranchName(branch string, date time.Time) string {
+	return strings.ReplaceAll(branch, "{date}", date.Format("2006-01-02"))
+}
+
 // Helper functions
 
-func isGitPath(path string) bool {
-	return filepath.Base(path) == ".git" || filepath.Dir(path) == ".git"
+func devMetricsSignature(when time.Time) object.Signature {
+	return object.Signature{
+		Name:  "Dev Metrics",
+		Email: "dev@metrics.local",
+		When:  when,
+	}
+}
+
+// storeBlob writes content to the object store and returns its hash
+func (g *GitOperations) storeBlob(content []byte) (plumbing.Hash, error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		w.Close()
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
 }
 
-func isRegularFile(path string) bool {
-	info, err := os.Stat(path)
+// storeObject encodes a tree, commit or tag into the object store
+func (g *GitOperations) storeObject(o interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := o.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode object: %w", err)
+	}
+	hash, err := g.repo.Storer.SetEncodedObject(obj)
 	if err != nil {
-		return false
+		return plumbing.ZeroHash, fmt.Errorf("failed to store object: %w", err)
+	}
+	return hash, nil
+}
+
+// matchesAny reports whether a slash-separated path matches any pattern
+func matchesAny(patterns []string, file string) bool {
+	for _, pattern := range patterns {
+		target := file
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(file)
+		}
+		if ok, _ := doublestar.Match(pattern, target); ok {
+			return true
+		}
 	}
-	return info.Mode().IsRegular()
+	return false
 }
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RunLedger records everything a generate run created so it can be listed
+// and reverted later
+type RunLedger struct {
+	ID           string           `json:"id"`
+	Persona      string           `json:"persona,omitempty"`
+	StartedAt    time.Time        `json:"started_at"`
+	FinishedAt   *time.Time       `json:"finished_at,omitempty"`
+	RevertedAt   *time.Time       `json:"reverted_at,omitempty"`
+	Repositories []*RepositoryRun `json:"repositories"`
+}
+
+// RepositoryRun records the commits a run created in one repository
+type RepositoryRun struct {
+	Path            string            `json:"path"`
+	WorkPath        string            `json:"work_path,omitempty"`
+	Sandbox         bool              `json:"sandbox,omitempty"`
+	Branch          string            `json:"branch,omitempty"` // empty when HEAD was detached
+	CreatedBranch   bool              `json:"created_branch,omitempty"`
+	BaseCommit      string            `json:"base_commit"`
+	TopicBranches   []string          `json:"topic_branches,omitempty"`
+	ReleaseBranches []string          `json:"release_branches,omitempty"`
+	BranchBases     map[string]string `json:"branch_bases,omitempty"` // commit each topic and release branch started from
+	Tags            []string          `json:"tags,omitempty"`
+	Commits         []LedgerCommit    `json:"commits"`
+	Checkpoint      Checkpoint        `json:"checkpoint"`
+}
+
+// Checkpoint is how far a repository's plan got, saved after every planned
+// commit so an interrupted run can be resumed
+type Checkpoint struct {
+	Position int                       `json:"position"` // index of the next planned commit
+	Done     bool                      `json:"done,omitempty"`
+	Topics   map[string]*TopicProgress `json:"topics,omitempty"`   // keyed by the topic branch name in the plan
+	Changes  []string                  `json:"changes,omitempty"`  // commit subjects since the last release
+	Hotfixes []string                  `json:"hotfixes,omitempty"` // hotfix commit subjects since the last release
+}
+
+// TopicProgress is a topic branch created during a run
+type TopicProgress struct {
+	Name     string   `json:"name"`
+	Subjects []string `json:"subjects,omitempty"` // first line of every commit made on the branch
+}
+
+// LedgerCommit is a single commit created during a run
+type LedgerCommit struct {
+	Hash       string    `json:"hash"`
+	CommitDate time.Time `json:"commit_date"`
+	CreatedAt  time.Time `json:"created_at"`
+	Branch     string    `json:"branch,omitempty"` // topic branch, empty for the run's branch
+}
+
+// NewRunLedger starts a ledger for a new run
+func NewRunLedger(persona string) *RunLedger {
+	now := time.Now()
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+
+	return &RunLedger{
+		ID:        fmt.Sprintf("%s-%s", now.Format("20060102-150405"), hex.EncodeToString(suffix)),
+		Persona:   persona,
+		StartedAt: now,
+	}
+}
+
+// AddRepository registers a repository with the run and returns its record
+func (l *RunLedger) AddRepository(run RepositoryRun) *RepositoryRun {
+	l.Repositories = append(l.Repositories, &run)
+	return &run
+}
+
+// RecordBranch registers a topic or release branch the run is about to create
+// at base
+func (r *RepositoryRun) RecordBranch(name, base string) {
+	if r.BranchBases == nil {
+		r.BranchBases = make(map[string]string)
+	}
+	r.BranchBases[name] = base
+}
+
+// FindRepository returns the record of the repository at path, or nil if the
+// run has not reached it
+func (l *RunLedger) FindRepository(path string) *RepositoryRun {
+	for _, repo := range l.Repositories {
+		if filepath.Clean(repo.Path) == filepath.Clean(path) {
+			return repo
+		}
+	}
+	return nil
+}
+
+// NumCommits returns the total number of commits created by the run
+func (l *RunLedger) NumCommits() int {
+	total := 0
+	for _, repo := range l.Repositories {
+		total += len(repo.Commits)
+	}
+	return total
+}
+
+// LastCommit returns the last commit created on the run's branch itself,
+// ignoring topic branches, or an empty string if there is none
+func (r *RepositoryRun) LastCommit() string {
+	for i := len(r.Commits) - 1; i >= 0; i-- {
+		if r.Commits[i].Branch == "" {
+			return r.Commits[i].Hash
+		}
+	}
+	return ""
+}
+
+// BranchTips returns the last commit the run created on each branch, keyed by
+// branch name, or the commit the branch started from if the run has not
+// committed on it yet. A detached HEAD is keyed by an empty name.
+func (r *RepositoryRun) BranchTips() map[string]string {
+	tips := make(map[string]string)
+	for branch, base := range r.BranchBases {
+		tips[branch] = base
+	}
+	if r.BaseCommit != "" {
+		tips[r.Branch] = r.BaseCommit
+	}
+	for _, commit := range r.Commits {
+		branch := commit.Branch
+		if branch == "" {
+			branch = r.Branch
+		}
+		tips[branch] = commit.Hash
+	}
+	return tips
+}
+
+// Finish marks the run as complete
+func (l *RunLedger) Finish() {
+	now := time.Now()
+	l.FinishedAt = &now
+}
+
+// Status summarizes where the run is in its lifecycle
+func (l *RunLedger) Status() string {
+	switch {
+	case l.RevertedAt != nil:
+		return "reverted"
+	case l.FinishedAt != nil:
+		return "finished"
+	default:
+		return "incomplete"
+	}
+}
+
+// SaveLedger writes the ledger to its run directory under stateDir
+func SaveLedger(stateDir string, ledger *RunLedger) error {
+	dir := runDir(stateDir, ledger.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create run directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(ledger, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger: %w", err)
+	}
+
+	// Write through a temp file so an interrupted save never truncates the ledger
+	path := filepath.Join(dir, "ledger.json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write ledger: %w", err)
+	}
+
+	return nil
+}
+
+// LoadLedger reads the ledger of the given run
+func LoadLedger(stateDir, runID string) (*RunLedger, error) {
+	data, err := os.ReadFile(filepath.Join(runDir(stateDir, runID), "ledger.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unknown run: %s", runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	var ledger RunLedger
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse ledger for run %s: %w", runID, err)
+	}
+
+	return &ledger, nil
+}
+
+// ListLedgers returns every recorded run, oldest first
+func ListLedgers(stateDir string) ([]*RunLedger, error) {
+	entries, err := os.ReadDir(filepath.Join(stateDir, "runs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	var ledgers []*RunLedger
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		ledger, err := LoadLedger(stateDir, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		ledgers = append(ledgers, ledger)
+	}
+
+	sort.Slice(ledgers, func(i, j int) bool {
+		return ledgers[i].StartedAt.Before(ledgers[j].StartedAt)
+	})
+
+	return ledgers, nil
+}
+
+// RunFile returns the path of a file kept alongside a run's ledger
+func RunFile(stateDir, runID, name string) string {
+	return filepath.Join(runDir(stateDir, runID), name)
+}
+
+func runDir(stateDir, runID string) string {
+	return filepath.Join(stateDir, "runs", runID)
+}
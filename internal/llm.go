@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mauza/gollm"
+)
+
+// ProviderOffline generates changes locally from templates instead of
+// calling an LLM, so runs need neither network access nor an API key
+const ProviderOffline = "offline"
+
+// Task is the kind of text an LLM request asks for
+type Task string
+
+const (
+	TaskCodeChange     Task = "code_change"
+	TaskStructured     Task = "structured_change" // code change, summary and commit message as JSON
+	TaskChangeSummary  Task = "change_summary"
+	TaskNewFile        Task = "new_file"
+	TaskNewFileSummary Task = "new_file_summary"
+	TaskCommitMessage  Task = "commit_message"
+	TaskPing           Task = "ping"
+)
+
+// Request is a single request to an LLM. Providers that talk to a model send
+// the prompt, the offline provider works from the task and its inputs.
+type Request struct {
+	Task     Task
+	Prompt   *gollm.Prompt
+	File     string // file the request is about, if any
+	Input    string // file content, template content, diff or change summary
+	Template string // path of the file a new file is modelled on
+	Attempt  int    // how many earlier answers to the request were rejected
+	// Description is what a structured change is for, which its commit
+	// message is based on
+	Description string
+	Schema      map[string]any // JSON schema the response must follow, if any
+}
+
+// key identifies the request made with the given LLM settings in response
+// caches. Prompts alone are not enough: the prompt asking to summarize a
+// file's changes is the same for every change to that file.
+func (r Request) key(settings string) string {
+	input := sha256.Sum256([]byte(r.Input))
+	parts := []string{settings, string(r.Task), r.Prompt.String(), r.File, hex.EncodeToString(input[:]), r.Template, strconv.Itoa(r.Attempt), r.Description}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// LLM produces the text asked for by a request
+type LLM interface {
+	Generate(ctx context.Context, req Request) (string, error)
+}
+
+// gollmProvider sends requests to a model through gollm
+type gollmProvider struct {
+	llm gollm.LLM
+}
+
+func (p *gollmProvider) Generate(ctx context.Context, req Request) (string, error) {
+	if req.Schema != nil {
+		return p.llm.GenerateWithSchema(ctx, req.Prompt, req.Schema)
+	}
+	return p.llm.Generate(ctx, req.Prompt)
+}
+
+// ConnectLLM sets up the LLM described by cfg, reading its API key from the
+// configured environment variable unless the provider is offline
+func ConnectLLM(cfg LLMConfig) (*LLMOperations, error) {
+	prompts, err := NewPromptTemplates(cfg.Prompts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Provider == ProviderOffline {
+		llm := NewLLMOperationsFor(NewOfflineLLM(cfg.Seed))
+		llm.model = ProviderOffline
+		llm.settings = fmt.Sprintf("%s seed=%d", ProviderOffline, cfg.Seed)
+		llm.validation = cfg.Validation
+		llm.prompts = prompts
+		llm.promptTokens = cfg.PromptTokens
+		llm.structured = cfg.Structured
+		return llm, nil
+	}
+
+	apiKey := os.Getenv(cfg.APIKeyEnvVar)
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key environment variable %s is not set", cfg.APIKeyEnvVar)
+	}
+
+	llm, err := NewLLMOperations(cfg, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	llm.validation = cfg.Validation
+	llm.prompts = prompts
+	llm.promptTokens = cfg.PromptTokens
+	llm.structured = cfg.Structured
+	llm.SetRateLimiter(NewRateLimiter(cfg.RequestsPerMinute, cfg.TokensPerMinute))
+	return llm, nil
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ResponseCache remembers the LLM responses of a run by request key in an
+// append-only file, so a resumed run gets the same answers without calling
+// the LLM again
+type ResponseCache struct {
+	mu        sync.Mutex
+	path      string
+	responses map[string]string
+}
+
+type cacheEntry struct {
+	Key      string `json:"key"`
+	Response string `json:"response"`
+}
+
+// LoadResponseCache reads the cache at path, starting empty if it does not
+// exist yet
+func LoadResponseCache(path string) (*ResponseCache, error) {
+	cache := &ResponseCache{path: path, responses: make(map[string]string)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LLM cache: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry cacheEntry
+		// A line cut short by a crash is simply not cached
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		cache.responses[entry.Key] = entry.Response
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read LLM cache: %w", err)
+	}
+
+	return cache, nil
+}
+
+// Get returns the cached response for key
+func (c *ResponseCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response, ok := c.responses[key]
+	return response, ok
+}
+
+// Put caches the response for key and appends it to the cache file
+func (c *ResponseCache) Put(key, response string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := cacheEntry{Key: key, Response: response}
+	c.responses[entry.Key] = response
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal LLM cache entry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create LLM cache directory: %w", err)
+	}
+	f, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open LLM cache: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write LLM cache: %w", err)
+	}
+	return nil
+}
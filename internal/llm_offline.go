@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OfflineLLM answers requests from templates instead of a model. Every
+// answer is derived from the seed and the request alone, so the same plan
+// applied with the same seed always produces the same commits.
+type OfflineLLM struct {
+	seed int64
+}
+
+// NewOfflineLLM creates an offline provider
+func NewOfflineLLM(seed int64) *OfflineLLM {
+	return &OfflineLLM{seed: seed}
+}
+
+// offlineNotes are the comments the offline provider adds to files. They
+// are recognised again later so files do not grow without bound.
+var offlineNotes = []string{
+	"Keep this in sync with the callers below",
+	"Validate inputs before doing any work",
+	"TODO: handle the empty case explicitly",
+	"NOTE: order matters here",
+	"Split this up if it grows any further",
+	"Prefer early returns to nested branches",
+	"Cache the result if this shows up in profiles",
+	"Guard against nil before dereferencing",
+}
+
+var offlineAddSummaries = []string{
+	"Clarify the intent of %s with a short comment",
+	"Document an assumption in %s",
+	"Leave a note for future changes to %s",
+}
+
+var offlineRemoveSummaries = []string{
+	"Drop an outdated comment from %s",
+	"Tidy up the comments in %s",
+}
+
+var offlineNewFileSummaries = []string{
+	"Add a %s skeleton",
+	"Introduce %s as a starting point",
+	"Scaffold %s",
+}
+
+// offlineCommitTypes maps words in a change description to the conventional
+// commit type used for it, checked in order
+var offlineCommitTypes = []struct {
+	words      []string
+	commitType string
+}{
+	{[]string{"fix", "bug", "leak", "edge case"}, "fix"},
+	{[]string{"test"}, "test"},
+	{[]string{"doc", "readme", "comment"}, "docs"},
+	{[]string{"optimize", "performance", "speed"}, "perf"},
+	{[]string{"refactor", "clean", "simplify", "restructure", "rename", "move"}, "refactor"},
+	{[]string{"add", "implement", "introduce", "support", "feature"}, "feat"},
+}
+
+// Generate answers a request without leaving the machine
+func (o *OfflineLLM) Generate(ctx context.Context, req Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	rng := o.rand(req)
+	switch req.Task {
+	case TaskCodeChange:
+		modified, err := o.editFile(rng, req.File, req.Input)
+		if err != nil {
+			return "", err
+		}
+		return UnifiedDiff(req.File, req.Input, modified), nil
+	case TaskChangeSummary:
+		return o.changeSummary(rng, req.File, req.Input), nil
+	case TaskStructured:
+		modified, err := o.editFile(rng, req.File, req.Input)
+		if err != nil {
+			return "", err
+		}
+		diff := UnifiedDiff(req.File, req.Input, modified)
+		summary := o.changeSummary(rng, req.File, diff)
+		change := StructuredChange{
+			Patch:         diff,
+			Summary:       summary,
+			CommitMessage: o.commitMessage(fmt.Sprintf("%s\n\nChanges:\n- %s: %s", req.Description, path.Base(filepath.ToSlash(req.File)), summary)),
+		}
+		data, err := json.Marshal(change)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case TaskNewFile:
+		return o.newFile(req.File, req.Input), nil
+	case TaskNewFileSummary:
+		return fmt.Sprintf(pick(rng, offlineNewFileSummaries), path.Base(filepath.ToSlash(req.File))), nil
+	case TaskCommitMessage:
+		return o.commitMessage(req.Input), nil
+	case TaskPing:
+		return "OK", nil
+	}
+	return "", fmt.Errorf("offline provider does not support %s requests", req.Task)
+}
+
+// changeSummary describes a diff to a file in one sentence
+func (o *OfflineLLM) changeSummary(rng *rand.Rand, filePath, diff string) string {
+	summaries := offlineAddSummaries
+	if added, removed := DiffStats(diff); removed > added {
+		summaries = offlineRemoveSummaries
+	}
+	return fmt.Sprintf(pick(rng, summaries), path.Base(filepath.ToSlash(filePath)))
+}
+
+// rand returns a random source seeded by the provider's seed and the request
+func (o *OfflineLLM) rand(req Request) *rand.Rand {
+	h := fnv.New64a()
+	for _, part := range []string{string(req.Task), req.File, req.Input, strconv.Itoa(req.Attempt)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return rand.New(rand.NewSource(o.seed ^ int64(h.Sum64())))
+}
+
+// editFile adds a comment at a blank line of the file, or removes one added
+// earlier, keeping the surrounding indentation
+func (o *OfflineLLM) editFile(rng *rand.Rand, filePath, content string) (string, error) {
+	prefix, suffix, ok := commentSyntax(filePath)
+	if !ok {
+		return "", fmt.Errorf("offline provider cannot comment in %s", filepath.Base(filePath))
+	}
+
+	lines := strings.Split(content, "\n")
+
+	var added []int
+	used := make(map[string]bool)
+	for i, line := range lines {
+		if note, ok := offlineNote(strings.TrimSpace(line), prefix, suffix); ok {
+			added = append(added, i)
+			used[note] = true
+		}
+	}
+	if len(added) > 0 && (rng.Intn(3) == 0 || len(used) == len(offlineNotes)) {
+		i := added[rng.Intn(len(added))]
+		return strings.Join(append(lines[:i:i], lines[i+1:]...), "\n"), nil
+	}
+
+	var notes []string
+	for _, note := range offlineNotes {
+		if !used[note] {
+			notes = append(notes, note)
+		}
+	}
+
+	// Insert before a line that follows a blank line, so the comment heads a
+	// block instead of splitting one. Files without blank lines get it
+	// before any line that is not already a note.
+	var candidates, fallback []int
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if _, ok := offlineNote(trimmed, prefix, suffix); ok || trimmed == "" {
+			continue
+		}
+		if i > 0 && strings.TrimSpace(lines[i-1]) == "" {
+			candidates = append(candidates, i)
+		}
+		fallback = append(fallback, i)
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+	at := 0
+	if len(candidates) > 0 {
+		at = candidates[rng.Intn(len(candidates))]
+	}
+
+	indent := ""
+	if at < len(lines) {
+		indent = lines[at][:len(lines[at])-len(strings.TrimLeft(lines[at], " \t"))]
+	}
+	comment := indent + prefix + " " + pick(rng, notes)
+	if suffix != "" {
+		comment += " " + suffix
+	}
+
+	result := make([]string, 0, len(lines)+1)
+	result = append(result, lines[:at]...)
+	result = append(result, comment)
+	result = append(result, lines[at:]...)
+	return strings.Join(result, "\n"), nil
+}
+
+// offlineNote returns the note a line holds if it is a comment added by the
+// offline provider
+func offlineNote(line, prefix, suffix string) (string, bool) {
+	text, ok := strings.CutPrefix(line, prefix)
+	if !ok {
+		return "", false
+	}
+	text, ok = strings.CutSuffix(text, suffix)
+	if !ok {
+		return "", false
+	}
+	text = strings.TrimSpace(text)
+	for _, note := range offlineNotes {
+		if text == note {
+			return note, true
+		}
+	}
+	return "", false
+}
+
+// commentSyntax returns how a line comment is opened and closed in a file
+func commentSyntax(filePath string) (string, string, bool) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".go", ".js", ".jsx", ".ts", ".tsx", ".java", ".c", ".h", ".cc", ".cpp", ".hpp",
+		".cs", ".rs", ".swift", ".kt", ".scala", ".php", ".dart":
+		return "//", "", true
+	case ".py", ".rb", ".sh", ".bash", ".yaml", ".yml", ".toml", ".pl", ".r", ".ex", ".exs", ".tf":
+		return "#", "", true
+	case ".sql", ".lua", ".hs":
+		return "--", "", true
+	case ".md", ".html", ".xml", ".vue", ".svelte":
+		return "<!--", "-->", true
+	case ".css", ".scss", ".less":
+		return "/*", "*/", true
+	}
+
+	switch filepath.Base(filePath) {
+	case "Makefile", "Dockerfile", ".gitignore":
+		return "#", "", true
+	}
+	return "", "", false
+}
+
+var goPackageClause = regexp.MustCompile(`(?m)^package\s+(\w+)`)
+
+// newFile writes a small skeleton for a new file in the file's language
+func (o *OfflineLLM) newFile(filePath, template string) string {
+	base := filepath.Base(filePath)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".go":
+		pkg := "main"
+		if match := goPackageClause.FindStringSubmatch(template); match != nil {
+			pkg = match[1]
+		}
+		return fmt.Sprintf("package %s\n\n// %s holds the %s helpers\n", pkg, identifier(name, true), name)
+	case ".py":
+		return fmt.Sprintf("\"\"\"Helpers for %s.\"\"\"\n\n\ndef %s():\n    pass\n", name, identifier(name, false))
+	case ".js", ".jsx", ".ts", ".tsx":
+		return fmt.Sprintf("// Helpers for %s\n\nexport function %s() {}\n", name, identifier(name, false))
+	case ".md":
+		return fmt.Sprintf("# %s\n\nTODO: describe %s.\n", name, name)
+	}
+
+	if prefix, suffix, ok := commentSyntax(filePath); ok {
+		comment := prefix + " " + name
+		if suffix != "" {
+			comment += " " + suffix
+		}
+		return comment + "\n"
+	}
+	return name + "\n"
+}
+
+// identifier turns a file name into an identifier, exported if requested
+func identifier(name string, exported bool) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	result := strings.Join(words, "_")
+	if result == "" {
+		result = "placeholder"
+	}
+	if exported {
+		return strings.ToUpper(result[:1]) + result[1:]
+	}
+	return result
+}
+
+// commitMessage writes a conventional commit message from a change summary:
+// the description on its first line followed by a "Changes:" list
+func (o *OfflineLLM) commitMessage(changes string) string {
+	description, rest, _ := strings.Cut(strings.TrimSpace(changes), "\n")
+	description = strings.TrimSpace(description)
+	if description == "" {
+		description = "Update files"
+	}
+
+	commitType := "chore"
+	lower := strings.ToLower(description)
+types:
+	for _, candidate := range offlineCommitTypes {
+		for _, word := range candidate.words {
+			if strings.Contains(lower, word) {
+				commitType = candidate.commitType
+				break types
+			}
+		}
+	}
+
+	var items []string
+	scope := ""
+	for _, line := range strings.Split(rest, "\n") {
+		item, ok := strings.CutPrefix(strings.TrimSpace(line), "- ")
+		if !ok {
+			continue
+		}
+		items = append(items, "- "+item)
+		if scope == "" {
+			file, _, _ := strings.Cut(item, ":")
+			file = strings.TrimSuffix(file, " (new)")
+			scope = strings.TrimSuffix(file, filepath.Ext(file))
+		}
+	}
+
+	subject := commitType
+	if scope != "" {
+		subject += "(" + scope + ")"
+	}
+	first, size := utf8.DecodeRuneInString(description)
+	subject += ": " + string(unicode.ToLower(first)) + description[size:]
+	if len(subject) > maxSubjectLength {
+		// Cut on a rune boundary so the subject stays valid UTF-8
+		cut := maxSubjectLength
+		for !utf8.RuneStart(subject[cut]) {
+			cut--
+		}
+		subject = strings.TrimSpace(subject[:cut])
+	}
+
+	if len(items) == 0 {
+		return subject
+	}
+	return subject + "\n\n" + strings.Join(items, "\n")
+}
+
+func pick(rng *rand.Rand, options []string) string {
+	return options[rng.Intn(len(options))]
+}
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOfflineCommitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes string
+		want    string
+	}{
+		{"scope from the first file", "Fix nil check\n- store.go: guard empty names", "fix(store): fix nil check\n\n- store.go: guard empty names"},
+		{"non-ASCII first letter", "Éviter les doublons", "chore: éviter les doublons"},
+		{"empty summary", "", "chore: update files"},
+		{"long subject cut on a rune boundary", "Update " + strings.Repeat("é", 40), "chore: update " + strings.Repeat("é", 29)},
+	}
+
+	o := NewOfflineLLM(1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := o.commitMessage(tt.changes)
+			if got != tt.want {
+				t.Errorf("commitMessage() = %q, want %q", got, tt.want)
+			}
+			subject := Subject(got)
+			if !utf8.ValidString(subject) || len(subject) > maxSubjectLength {
+				t.Errorf("subject %q is not valid UTF-8 of at most %d bytes", subject, maxSubjectLength)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mauza/gollm"
@@ -10,50 +13,262 @@ import (
 
 // LLMOperations handles interactions with the LLM model
 type LLMOperations struct {
-	llm gollm.LLM
+	llm        LLM
+	model      string
+	settings   string // everything besides the request that shapes a response
+	cache      *ResponseCache
+	store      *ResponseStore
+	validation OutputValidationConfig
+	prompts    *PromptTemplates
+	modifier   *FileModifier
+	// Files larger than promptTokens are edited one function or region at a
+	// time, zero sends every file whole
+	promptTokens int
+	structured   bool // one JSON response per file instead of separate requests
+
+	timeout time.Duration // per request, zero waits as long as the context allows
+	retries int
+	backoff time.Duration
+	limiter *RateLimiter
+
+	mu             sync.Mutex // LLM operations may be used by several workers at once
+	requests, hits int
 }
 
+// Defaults for requests to an LLM unless configured otherwise
+const (
+	DefaultLLMTimeout = 300 * time.Second
+	DefaultLLMRetries = 3
+	DefaultLLMBackoff = 2 * time.Second
+	// DefaultLLMConcurrency is how many changes are generated at once
+	DefaultLLMConcurrency = 4
+)
+
 // NewLLMOperations creates a new LLM operations instance
-func NewLLMOperations(provider string, endpoint string, apiKey string, model string, temperature float64, maxTokens int) (*LLMOperations, error) {
-	llm, err := gollm.NewLLM(
-		gollm.SetProvider(provider),
-		gollm.SetModel(model),
-		gollm.SetEndpoint(endpoint),
+func NewLLMOperations(cfg LLMConfig, apiKey string) (*LLMOperations, error) {
+	// Retries happen here, where they can be cancelled and backed off
+	options := []gollm.ConfigOption{
+		gollm.SetProvider(cfg.Provider),
+		gollm.SetModel(cfg.Model),
+		gollm.SetEndpoint(cfg.Endpoint),
 		gollm.SetAPIKey(apiKey),
-		gollm.SetMaxTokens(maxTokens),
-		gollm.SetTemperature(temperature),
-		gollm.SetMaxRetries(3),
-		gollm.SetTimeout(300*time.Second),
-	)
+		gollm.SetMaxTokens(cfg.MaxTokens),
+		gollm.SetTemperature(cfg.Temperature),
+		gollm.SetMaxRetries(0),
+	}
+	if cfg.Timeout > 0 {
+		options = append(options, gollm.SetTimeout(cfg.Timeout))
+	}
+	llm, err := gollm.NewLLM(options...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize LLM model: %w", err)
 	}
 
+	ops := NewLLMOperationsFor(&gollmProvider{llm: llm})
+	ops.model = cfg.Model
+	ops.settings = fmt.Sprintf("%s model=%s temperature=%v max_tokens=%d", cfg.Provider, cfg.Model, cfg.Temperature, cfg.MaxTokens)
+	ops.SetRetries(cfg.Timeout, cfg.Retries, cfg.Backoff)
+	return ops, nil
+}
+
+// NewLLMOperationsFor creates LLM operations on top of any LLM provider
+func NewLLMOperationsFor(llm LLM) *LLMOperations {
+	// The default templates are known to parse
+	prompts, _ := NewPromptTemplates(PromptConfig{})
 	return &LLMOperations{
-		llm: llm,
-	}, nil
+		llm:          llm,
+		prompts:      prompts,
+		modifier:     NewFileModifier(),
+		promptTokens: DefaultPromptTokens,
+	}
+}
+
+// SetRetries gives up on each request after timeout and retries failed
+// requests up to retries times, doubling the wait from backoff each time
+func (l *LLMOperations) SetRetries(timeout time.Duration, retries int, backoff time.Duration) {
+	l.timeout = timeout
+	l.retries = retries
+	l.backoff = backoff
+}
+
+// SetRateLimiter has every request to the LLM wait for the limiter
+func (l *LLMOperations) SetRateLimiter(limiter *RateLimiter) {
+	l.limiter = limiter
+}
+
+// SetCache answers requests the run has made before from cache instead of
+// the LLM
+func (l *LLMOperations) SetCache(cache *ResponseCache) {
+	l.cache = cache
 }
 
-// GenerateCommitMessage generates a commit message based on the changes
-func (l *LLMOperations) GenerateCommitMessage(changes string) (string, error) {
+// SetStore answers requests any run has made before from a shared store
+// instead of the LLM
+func (l *LLMOperations) SetStore(store *ResponseStore) {
+	l.store = store
+}
+
+// CacheHits returns how many requests were made and how many of them were
+// answered from cache
+func (l *LLMOperations) CacheHits() (int, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.requests, l.hits
+}
+
+// count records a request, and whether it was answered from cache
+func (l *LLMOperations) count(hit bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests++
+	if hit {
+		l.hits++
+	}
+}
+
+// generate sends a request to the LLM unless its response is cached
+func (l *LLMOperations) generate(ctx context.Context, req Request) (string, error) {
+	key := req.key(l.settings)
+
+	if l.cache != nil {
+		if response, ok := l.cache.Get(key); ok {
+			l.count(true)
+			return response, nil
+		}
+	}
+	if l.store != nil {
+		if response, ok := l.store.Get(key); ok {
+			l.count(true)
+			l.remember(key, response)
+			return response, nil
+		}
+	}
+	l.count(false)
+
+	response, err := l.call(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	l.remember(key, response)
+	if l.store != nil {
+		entry := StoredResponse{
+			Key:       key,
+			Model:     l.model,
+			Task:      req.Task,
+			File:      req.File,
+			CreatedAt: time.Now(),
+			Response:  response,
+		}
+		if err := l.store.Put(entry); err != nil {
+			fmt.Printf("Failed to cache LLM response: %v\n", err)
+		}
+	}
+	return response, nil
+}
+
+// call sends a request to the LLM, retrying failures with exponential
+// backoff until the retries run out or ctx is cancelled
+func (l *LLMOperations) call(ctx context.Context, req Request) (string, error) {
+	// Roughly four characters to a token
+	tokens := len(req.Prompt.String()) / 4
+
+	delay := l.backoff
+	for attempt := 0; ; attempt++ {
+		if err := l.limiter.Wait(ctx, tokens); err != nil {
+			return "", err
+		}
+
+		response, err := l.callOnce(ctx, req)
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if attempt >= l.retries {
+			if attempt > 0 {
+				return "", fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+			}
+			return "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// callOnce sends a request to the LLM once, giving up after the timeout
+func (l *LLMOperations) callOnce(ctx context.Context, req Request) (string, error) {
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	response, err := l.llm.Generate(ctx, req)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		return "", fmt.Errorf("no response within %s", l.timeout)
+	}
+	return response, err
+}
+
+// remember adds a response to the run's own cache
+func (l *LLMOperations) remember(key, response string) {
+	if l.cache == nil {
+		return
+	}
+	if err := l.cache.Put(key, response); err != nil {
+		fmt.Printf("Failed to cache LLM response: %v\n", err)
+	}
+}
+
+// Ping sends the LLM a tiny prompt, bypassing the cache, to check that it
+// answers
+func (l *LLMOperations) Ping(ctx context.Context) error {
+	response, err := l.callOnce(ctx, Request{
+		Task:   TaskPing,
+		Prompt: gollm.NewPrompt("Reply with the single word OK."),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reach LLM: %w", err)
+	}
+	if strings.TrimSpace(response) == "" {
+		return fmt.Errorf("LLM returned an empty response")
+	}
+	return nil
+}
+
+// GenerateCommitMessage generates a commit message from a summary of the
+// changes and the diffs they were summarized from, so the message can be
+// checked against what actually changed
+func (l *LLMOperations) GenerateCommitMessage(ctx context.Context, changes string, diffs []FileDiff) (string, error) {
 	prompt := gollm.NewPrompt(fmt.Sprintf(`Given these code changes:
 
 %s
 
+The changes were summarized from this diff:
+
+%s
 Generate a concise, professional git commit message following these rules:
 - Use present tense
 - Start with a verb
 - Be specific but concise
 - Max 72 characters for first line
-- Optional: Add detailed description after blank line`, changes),
+- Optional: Add detailed description after blank line`, changes, FormatDiffs(diffs, l.promptTokens*4)),
 		gollm.WithDirectives(
 			"Be professional",
 			"Be specific",
 			"Use conventional commit format",
+			"Only describe changes the diff shows",
 		),
 	)
 
-	response, err := l.llm.Generate(context.Background(), prompt)
+	response, err := l.generate(ctx, Request{Task: TaskCommitMessage, Prompt: prompt, Input: changes})
 	if err != nil {
 		return "", fmt.Errorf("failed to generate commit message: %w", err)
 	}
@@ -61,36 +276,251 @@ Generate a concise, professional git commit message following these rules:
 	return response, nil
 }
 
-// GenerateCodeChanges generates changes for a given file
-func (l *LLMOperations) GenerateCodeChanges(filePath, content string) (string, string, error) {
-	prompt := gollm.NewPrompt(fmt.Sprintf(`Review and suggest improvements for this code:
+// attempts returns how many times a file is asked for before giving up
+func (l *LLMOperations) attempts() int {
+	if l.validation.Attempts < 1 {
+		return 1
+	}
+	return l.validation.Attempts
+}
+
+// rejection tells the LLM why its previous answer was not used
+func rejection(err error) string {
+	if err == nil {
+		return ""
+	}
+	return fmt.Sprintf("\n\nYour previous answer was rejected: %v. Try again.", err)
+}
+
+// GenerateCodeChanges asks the LLM for a unified diff against a file and
+// returns the patched content. The change asked for follows the prompt
+// template for the pattern's change or commit type. Files larger than the
+// prompt token budget are edited one function at a time, sending only that
+// function and the lines around it. Only the diff comes back, so files larger
+// than the response token budget can still be edited. Diffs that do not apply
+// or leave the file broken are asked for again, up to the configured
+// attempts.
+func (l *LLMOperations) GenerateCodeChanges(ctx context.Context, pattern CommitPattern, filePath, content string) (string, string, error) {
+	instructions, err := l.prompts.Instructions(pattern, filePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	scope := chooseScope(l.modifier, pattern, filePath, content, l.promptTokens)
+	excerpt := scope.Excerpt()
+
+	var modified string
+	var rejected error
+	for attempt := 0; attempt < l.attempts(); attempt++ {
+		response, err := l.generate(ctx, Request{
+			Task:    TaskCodeChange,
+			Prompt:  codeChangePrompt(instructions, filePath+scope.Describe(), excerpt, rejected),
+			File:    filePath,
+			Input:   excerpt,
+			Attempt: attempt,
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate code changes: %w", err)
+		}
+
+		var patched string
+		patched, rejected = ApplyPatch(excerpt, response)
+		if rejected == nil {
+			// The whole file is checked, since an excerpt may not parse alone
+			modified = scope.Splice(patched)
+			rejected = CheckOutput(filePath, content, modified, l.validation.MaxChangeRatio)
+		}
+		if rejected == nil {
+			break
+		}
+	}
+	if rejected != nil {
+		return "", "", fmt.Errorf("rejected code changes: %w", rejected)
+	}
+
+	// Describe the change from the diff actually applied, not the response
+	diff := NewFileDiff(filePath, content, modified)
+	descPrompt := gollm.NewPrompt(fmt.Sprintf("Summarize the changes made to %s (%d lines added, %d removed) in one brief sentence, describing only what this diff shows:\n\n%s",
+		filePath, diff.Added, diff.Removed, diff.Diff))
+	description, err := l.generate(ctx, Request{Task: TaskChangeSummary, Prompt: descPrompt, File: filePath, Input: diff.Diff})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate change description: %w", err)
+	}
+
+	return modified, description, nil
+}
+
+// FileEdit is a generated change to a file
+type FileEdit struct {
+	Content     string // the file's new content
+	Description string
+	// Message is the commit message proposed along with the change, only
+	// asked for in structured output mode
+	Message string
+}
+
+// EditFile generates a change to a file, in a single structured request if
+// configured and with one request for the change and one to describe it
+// otherwise
+func (l *LLMOperations) EditFile(ctx context.Context, pattern CommitPattern, filePath, content string) (FileEdit, error) {
+	if l.structured {
+		return l.GenerateStructuredChange(ctx, pattern, filePath, content)
+	}
+	modified, description, err := l.GenerateCodeChanges(ctx, pattern, filePath, content)
+	return FileEdit{Content: modified, Description: description}, err
+}
+
+// GenerateStructuredChange asks the LLM for a change to a file, a summary of
+// it and a commit message in a single JSON response. Responses that are not
+// valid JSON, miss a field, or whose patch does not apply or leaves the file
+// broken, are asked for again, up to the configured attempts.
+func (l *LLMOperations) GenerateStructuredChange(ctx context.Context, pattern CommitPattern, filePath, content string) (FileEdit, error) {
+	instructions, err := l.prompts.Instructions(pattern, filePath)
+	if err != nil {
+		return FileEdit{}, err
+	}
+
+	scope := chooseScope(l.modifier, pattern, filePath, content, l.promptTokens)
+	excerpt := scope.Excerpt()
+
+	var edit FileEdit
+	var rejected error
+	for attempt := 0; attempt < l.attempts(); attempt++ {
+		response, err := l.generate(ctx, Request{
+			Task:        TaskStructured,
+			Prompt:      structuredChangePrompt(instructions, pattern.Description, filePath+scope.Describe(), excerpt, rejected),
+			File:        filePath,
+			Input:       excerpt,
+			Attempt:     attempt,
+			Description: pattern.Description,
+			Schema:      structuredChangeSchema,
+		})
+		if err != nil {
+			return FileEdit{}, fmt.Errorf("failed to generate code changes: %w", err)
+		}
+
+		var change StructuredChange
+		change, rejected = ParseStructuredChange(response)
+		if rejected != nil {
+			continue
+		}
+
+		var patched string
+		patched, rejected = ApplyPatch(excerpt, change.Patch)
+		if rejected == nil {
+			edit = FileEdit{Content: scope.Splice(patched), Description: change.Summary, Message: change.CommitMessage}
+			rejected = CheckOutput(filePath, content, edit.Content, l.validation.MaxChangeRatio)
+		}
+		if rejected == nil {
+			break
+		}
+	}
+	if rejected != nil {
+		return FileEdit{}, fmt.Errorf("rejected code changes: %w", rejected)
+	}
+
+	return edit, nil
+}
+
+func structuredChangePrompt(instructions, description, file, content string, rejected error) *gollm.Prompt {
+	return gollm.NewPrompt(fmt.Sprintf(`%s
 
 File: %s
 
 %s
 
-Provide ONLY the final version of the code with minimal, realistic improvements.`, filePath, content),
+Respond with a JSON object with these fields:
+- "patch": a unified diff against the file above with minimal, realistic changes
+- "summary": one brief sentence describing only what the patch changes
+- "commit_message": a conventional commit message for the patch, in present tense, at most 72 characters on its first line, for a commit described as: %s%s`, instructions, file, content, description, rejection(rejected)),
+		gollm.WithDirectives(
+			"Make minimal necessary changes",
+			"Maintain code style",
+			"Start every hunk with an @@ header and keep three lines of unchanged context around each change",
+			"Escape newlines inside JSON strings",
+		),
+		gollm.WithOutput("Respond with only the JSON object"),
+	)
+}
+
+func codeChangePrompt(instructions, file, content string, rejected error) *gollm.Prompt {
+	return gollm.NewPrompt(fmt.Sprintf(`%s
+
+File: %s
+
+%s
+
+Provide ONLY a unified diff against the file above with minimal, realistic changes.%s`, instructions, file, content, rejection(rejected)),
 		gollm.WithDirectives(
 			"Make minimal necessary changes",
 			"Maintain code style",
 			"Focus on readability and maintainability",
+			"Start every hunk with an @@ header and keep three lines of unchanged context around each change",
+			"Never repeat unchanged parts of the file outside the hunk context",
 		),
-		gollm.WithOutput("Respond with only the improved code"),
+		gollm.WithOutput("Respond with only the unified diff"),
 	)
+}
 
-	response, err := l.llm.Generate(context.Background(), prompt)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to generate code changes: %w", err)
+// GenerateNewFile writes the content of a new file in the style of an
+// existing template file. Content that is truncated or does not parse is
+// asked for again, up to the configured attempts.
+func (l *LLMOperations) GenerateNewFile(ctx context.Context, filePath, description, templatePath, templateContent string) (string, string, error) {
+	var content string
+	var rejected error
+	for attempt := 0; attempt < l.attempts(); attempt++ {
+		response, err := l.generate(ctx, Request{
+			Task:     TaskNewFile,
+			Prompt:   newFilePrompt(filePath, description, templatePath, templateContent, rejected),
+			File:     filePath,
+			Input:    templateContent,
+			Template: templatePath,
+			Attempt:  attempt,
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate new file: %w", err)
+		}
+
+		content, rejected = StripFences(response)
+		if rejected == nil {
+			rejected = CheckOutput(filePath, "", content, l.validation.MaxChangeRatio)
+		}
+		if rejected == nil {
+			break
+		}
+	}
+	if rejected != nil {
+		return "", "", fmt.Errorf("rejected new file: %w", rejected)
 	}
 
-	// Create a brief description of changes
-	descPrompt := gollm.NewPrompt(fmt.Sprintf("Summarize the changes made to %s in one brief sentence", filePath))
-	description, err := l.llm.Generate(context.Background(), descPrompt)
+	descPrompt := gollm.NewPrompt(fmt.Sprintf("Summarize what the new file %s adds in one brief sentence:\n\n%s", filePath, content))
+	summary, err := l.generate(ctx, Request{Task: TaskNewFileSummary, Prompt: descPrompt, File: filePath, Input: content})
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate change description: %w", err)
 	}
 
-	return response, description, nil
+	return content, summary, nil
+}
+
+func newFilePrompt(filePath, description, templatePath, templateContent string, rejected error) *gollm.Prompt {
+	return gollm.NewPrompt(fmt.Sprintf(`Write a new file for this change: %s
+
+New file: %s
+
+Follow the conventions of this existing file from the same project:
+
+File: %s
+
+%s
+
+Provide ONLY the content of the new file.%s`, description, filePath, templatePath, templateContent, rejection(rejected)),
+		gollm.WithDirectives(
+			"Keep the file small and focused",
+			"Match the existing code style",
+			"Only use code that plausibly exists in the project",
+		),
+		gollm.WithOutput("Respond with only the file content"),
+	)
 }
 
 // Close releases any resources
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ErrUnbornBranch is returned when branching from a branch with no commits
+var ErrUnbornBranch = errors.New("branch has no commits yet")
+
+// BranchPoint returns the commit a new branch started from the current tip of
+// from would point at
+func (g *GitOperations) BranchPoint(from string) (plumbing.Hash, error) {
+	tip, err := g.BranchTip(from)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) || (err == nil && tip.IsZero()) {
+		return plumbing.ZeroHash, ErrUnbornBranch
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return tip, nil
+}
+
+// StartBranchAt creates branch at commit without switching to it
+func (g *GitOperations) StartBranchAt(branch string, commit plumbing.Hash) error {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if g.HasBranch(branch) {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+
+	if g.objects != nil {
+		if _, ok := g.objects.tips[branchRef]; ok {
+			return fmt.Errorf("branch %s already exists", branch)
+		}
+
+		tree, err := g.commitTree(commit)
+		if err != nil {
+			return err
+		}
+		g.objects.tips[branchRef] = &objectTip{parent: commit, tree: tree}
+		g.objects.start[branchRef] = plumbing.ZeroHash
+		return nil
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(branchRef, commit)); err != nil {
+		return fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
+	return nil
+}
+
+// SwitchBranch makes branch the target of new commits. Changes that were
+// never committed are discarded.
+func (g *GitOperations) SwitchBranch(branch string) error {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+
+	if g.objects != nil {
+		if _, err := g.objectTipOf(branchRef); err != nil {
+			return fmt.Errorf("failed to read branch %s: %w", branch, err)
+		}
+		g.objects.target = branchRef
+		g.objects.pending = make(map[string]string)
+		g.objects.removed = make(map[string]bool)
+		return nil
+	}
+	if g.bare {
+		return errBareWorktree
+	}
+
+	// HEAD may point at an orphan branch that has no commits yet
+	symbolic, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+	if symbolic.Target() == branchRef {
+		return nil
+	}
+
+	head, err := g.repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %w", branch, err)
+	}
+
+	if g.originalHead == nil {
+		g.originalHead = head
+	}
+	return nil
+}
+
+// MergeBranch merges branch into the current branch with a commit dated
+// timestamp. Files changed on only one side since the merge base take that
+// side's version, files changed on both are merged line by line with
+// conflicting lines resolved in favour of the current branch. A squash merge
+// records the same tree as a regular commit with a single parent.
+func (g *GitOperations) MergeBranch(branch, message string, timestamp time.Time, squash bool) (plumbing.Hash, error) {
+	if g.bare && g.objects == nil {
+		return plumbing.ZeroHash, errBareWorktree
+	}
+
+	_, oursHash, err := g.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	theirsHash, err := g.BranchTip(branch)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	ours, err := g.repo.CommitObject(oursHash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read commit %s: %w", oursHash, err)
+	}
+	theirs, err := g.repo.CommitObject(theirsHash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read commit %s: %w", theirsHash, err)
+	}
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to find merge base: %w", err)
+	}
+	if len(bases) == 0 {
+		return plumbing.ZeroHash, fmt.Errorf("branch %s shares no history with the current branch", branch)
+	}
+	if bases[0].Hash == theirs.Hash {
+		return plumbing.ZeroHash, fmt.Errorf("branch %s is already merged", branch)
+	}
+
+	tree, err := g.applyBranchChanges(ours, bases[0], theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	commit := &object.Commit{
+		Author:       devMetricsSignature(timestamp),
+		Committer:    devMetricsSignature(timestamp),
+		Message:      message,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{ours.Hash, theirs.Hash},
+	}
+	if squash {
+		commit.ParentHashes = commit.ParentHashes[:1]
+	}
+
+	hash, err := g.storeObject(commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create merge commit: %w", err)
+	}
+
+	if g.objects != nil {
+		tip := g.objects.current()
+		tip.parent = hash
+		tip.tree = tree
+		return hash, nil
+	}
+
+	// Resetting moves the checked out branch along with the worktree
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to update worktree: %w", err)
+	}
+	return hash, nil
+}
+
+// applyBranchChanges writes the changes between base and theirs on top of
+// the tree of ours and returns the resulting tree. Where ours changed a file
+// too, its change is kept: a file deleted on either side while the other
+// modified it stays as ours has it, and content changed on both sides is
+// merged.
+func (g *GitOperations) applyBranchChanges(ours, base, theirs *object.Commit) (plumbing.Hash, error) {
+	oursTree, err := ours.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read tree: %w", err)
+	}
+	baseTree, err := base.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read tree: %w", err)
+	}
+	theirsTree, err := theirs.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	changes, err := object.DiffTree(baseTree, theirsTree)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	tree := ours.TreeHash
+	for _, change := range changes {
+		action, err := change.Action()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to read change: %w", err)
+		}
+
+		name, blob, mode := change.To.Name, change.To.TreeEntry.Hash, change.To.TreeEntry.Mode
+		if action == merkletrie.Delete {
+			name, blob = change.From.Name, plumbing.ZeroHash
+		}
+
+		oursEntry, err := oursTree.FindEntry(name)
+		if err != nil && err != object.ErrEntryNotFound && err != object.ErrDirectoryNotFound {
+			return plumbing.ZeroHash, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		oursBlob := plumbing.ZeroHash
+		if oursEntry != nil {
+			oursBlob = oursEntry.Hash
+		}
+		// Files ours left alone simply take their version
+		baseBlob := change.From.TreeEntry.Hash
+		switch {
+		case oursBlob == blob:
+			continue
+		case oursBlob != baseBlob && (oursBlob.IsZero() || blob.IsZero()):
+			// Deleted on one side and modified on the other
+			continue
+		case oursBlob != baseBlob:
+			merged, err := g.mergeFile(name, baseTree, oursTree, theirsTree)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if blob, err = g.storeBlob([]byte(merged)); err != nil {
+				return plumbing.ZeroHash, err
+			}
+			mode = oursEntry.Mode
+		}
+
+		tree, err = g.writeTreeChange(tree, strings.Split(name, "/"), blob, mode)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to merge %s: %w", name, err)
+		}
+	}
+	return tree, nil
+}
+
+// mergeFile merges the changes both sides made to a file since base, which
+// may not have had it
+func (g *GitOperations) mergeFile(name string, base, ours, theirs *object.Tree) (string, error) {
+	var contents [3]string
+	for i, tree := range []*object.Tree{base, ours, theirs} {
+		file, err := tree.File(name)
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if contents[i], err = file.Contents(); err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	return MergeLines(contents[0], contents[1], contents[2]), nil
+}
+
+// lineChange replaces lines start to end, exclusive, of a base text
+type lineChange struct {
+	start, end int
+	lines      []string
+}
+
+// MergeLines combines the changes ours and theirs each made to base. Changes
+// to separate lines are all kept; where both sides changed the same or
+// adjacent lines differently, ours wins, as with git merge -X ours.
+func MergeLines(base, ours, theirs string) string {
+	baseLines := strings.SplitAfter(base, "\n")
+	oursChanges := lineChanges(base, ours)
+	theirsChanges := lineChanges(base, theirs)
+
+	var out strings.Builder
+	pos := 0
+	for len(oursChanges) > 0 || len(theirsChanges) > 0 {
+		// Gather every change overlapping or touching the earliest one
+		first := oursChanges
+		if len(first) == 0 || len(theirsChanges) > 0 && theirsChanges[0].start < first[0].start {
+			first = theirsChanges
+		}
+		start, end := first[0].start, first[0].end
+		var oursGroup, theirsGroup []lineChange
+		for grew := true; grew; {
+			grew = false
+			for _, side := range []struct {
+				changes *[]lineChange
+				group   *[]lineChange
+			}{{&oursChanges, &oursGroup}, {&theirsChanges, &theirsGroup}} {
+				for len(*side.changes) > 0 {
+					c := (*side.changes)[0]
+					if c.start > end || c.end < start {
+						break
+					}
+					start = min(start, c.start)
+					if c.end > end {
+						end = c.end
+					}
+					*side.group = append(*side.group, c)
+					*side.changes = (*side.changes)[1:]
+					grew = true
+				}
+			}
+		}
+
+		// Both lists are sorted, so nothing before the group is left out
+		for _, line := range baseLines[pos:start] {
+			out.WriteString(line)
+		}
+		// Changes only one side made are taken as they are, changes both
+		// made are resolved in favour of ours
+		group := oursGroup
+		if len(group) == 0 {
+			group = theirsGroup
+		}
+		out.WriteString(applyLineChanges(baseLines, start, end, group))
+		pos = end
+	}
+	for _, line := range baseLines[pos:] {
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+// lineChanges lists the runs of lines other replaced in base, in order
+func lineChanges(base, other string) []lineChange {
+	var changes []lineChange
+	pos := 0
+	for _, d := range diff.Do(base, other) {
+		lines := strings.SplitAfter(d.Text, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+
+		if d.Type == diffmatchpatch.DiffEqual {
+			pos += len(lines)
+			continue
+		}
+		if n := len(changes); n == 0 || changes[n-1].end != pos {
+			changes = append(changes, lineChange{start: pos, end: pos})
+		}
+		c := &changes[len(changes)-1]
+		if d.Type == diffmatchpatch.DiffDelete {
+			c.end += len(lines)
+			pos += len(lines)
+		} else {
+			c.lines = append(c.lines, lines...)
+		}
+	}
+	return changes
+}
+
+// applyLineChanges returns base lines start to end with changes applied
+func applyLineChanges(baseLines []string, start, end int, changes []lineChange) string {
+	var out strings.Builder
+	pos := start
+	for _, c := range changes {
+		for _, line := range baseLines[pos:c.start] {
+			out.WriteString(line)
+		}
+		for _, line := range c.lines {
+			out.WriteString(line)
+		}
+		pos = c.end
+	}
+	for _, line := range baseLines[pos:end] {
+		out.WriteString(line)
+	}
+	return out.String()
+}
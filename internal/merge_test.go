@@ -0,0 +1,35 @@
+package internal
+
+import "testing"
+
+func TestMergeLines(t *testing.T) {
+	const base = "a\nb\nc\nd\ne\nf\ng\n"
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+	}{
+		{"only ours", base, "a\nB\nc\nd\ne\nf\ng\n", base, "a\nB\nc\nd\ne\nf\ng\n"},
+		{"only theirs", base, base, "a\nb\nc\nd\ne\nF\ng\n", "a\nb\nc\nd\ne\nF\ng\n"},
+		{"separate lines", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nb\nc\nd\ne\nF\ng\n", "a\nB\nc\nd\ne\nF\ng\n"},
+		{"same change", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n"},
+		{"conflict keeps ours", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nX\nc\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n"},
+		{"theirs inserts before ours", base, "a\nb\nc\nd\ne\nF\ng\n", "new\na\nb\nc\nd\ne\nf\ng\n", "new\na\nb\nc\nd\ne\nF\ng\n"},
+		{"theirs deletes, ours appends", base, base + "h\n", "a\nc\nd\ne\nf\ng\n", "a\nc\nd\ne\nf\ng\nh\n"},
+		{"added on both sides", "", "x\n", "y\n", "x\n"},
+		{"conflict on adjacent lines keeps ours", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nb\nC\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n"},
+		{"conflict on overlapping runs keeps ours", base, "a\nb\nC\nD\ne\nf\ng\n", "a\nB\nX\nd\ne\nf\ng\n", "a\nb\nC\nD\ne\nf\ng\n"},
+		{"ours deletes a line theirs edits", base, "a\nc\nd\ne\nf\ng\n", "a\nX\nc\nd\ne\nf\ng\n", "a\nc\nd\ne\nf\ng\n"},
+		{"theirs deletes a line ours edits", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nc\nd\ne\nf\ng\n", "a\nB\nc\nd\ne\nf\ng\n"},
+		{"conflict keeps theirs elsewhere", base, "a\nB\nc\nd\ne\nf\ng\n", "a\nX\nc\nd\ne\nF\ng\n", "a\nB\nc\nd\ne\nF\ng\n"},
+		{"appended on both sides", base, base + "h\n", base + "i\n", base + "h\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeLines(tt.base, tt.ours, tt.theirs); got != tt.want {
+				t.Errorf("MergeLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Commit backends
+const (
+	BackendWorktree = "worktree" // write files to disk, stage and commit
+	BackendObjects  = "objects"  // build blobs, trees and commits in the object store
+)
+
+// objectState tracks commits built directly in the object store. Nothing is
+// visible outside the object store until PublishRefs moves the refs.
+type objectState struct {
+	target  plumbing.ReferenceName                // ref new commits go to
+	tips    map[plumbing.ReferenceName]*objectTip // in-memory value of every ref touched
+	start   map[plumbing.ReferenceName]plumbing.Hash
+	pending map[string]string // files modified since the last commit
+	removed map[string]bool   // files deleted since the last commit
+
+	worktree plumbing.Hash // commit the worktree matches
+}
+
+// objectTip is the commit a ref points to and the tree the next commit on it
+// starts from. The parent is zero for an orphan branch with no commits yet.
+type objectTip struct {
+	parent plumbing.Hash
+	tree   plumbing.Hash
+}
+
+// current returns the tip new commits build on
+func (s *objectState) current() *objectTip {
+	return s.tips[s.target]
+}
+
+// UseObjectBackend switches commit construction to write blobs, trees and
+// commits straight into the object store. Refs are only updated, by
+// PublishRefs, once the commits they point to exist, and the worktree is only
+// brought up to date by Finish. This is the only backend that works with bare
+// repositories.
+func (g *GitOperations) UseObjectBackend() error {
+	head, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	resolved, err := g.repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	tree, err := g.commitTree(resolved.Hash())
+	if err != nil {
+		return err
+	}
+
+	target := plumbing.HEAD
+	if head.Type() == plumbing.SymbolicReference {
+		target = head.Target()
+	}
+
+	g.objects = &objectState{
+		target: target,
+		tips: map[plumbing.ReferenceName]*objectTip{
+			target: {parent: resolved.Hash(), tree: tree},
+		},
+		start: map[plumbing.ReferenceName]plumbing.Hash{
+			target: resolved.Hash(),
+		},
+		pending:  make(map[string]string),
+		removed:  make(map[string]bool),
+		worktree: resolved.Hash(),
+	}
+	return nil
+}
+
+// IsBare reports whether the repository has no worktree
+func (g *GitOperations) IsBare() bool {
+	return g.bare
+}
+
+// PublishRefs makes the commits built by the object backend visible by
+// moving every ref that gained commits. Neither HEAD nor the worktree are
+// touched, even when the checked out branch moved. It is a no-op for the
+// worktree backend.
+func (g *GitOperations) PublishRefs() error {
+	if g.objects == nil {
+		return nil
+	}
+
+	for name, tip := range g.objects.tips {
+		if tip.parent.IsZero() || tip.parent == g.objects.start[name] {
+			continue
+		}
+
+		if err := g.repo.Storer.SetReference(plumbing.NewHashReference(name, tip.parent)); err != nil {
+			return fmt.Errorf("failed to update %s: %w", name.Short(), err)
+		}
+		g.objects.start[name] = tip.parent
+	}
+	return nil
+}
+
+// Finish publishes the commits built by the object backend and, when they
+// went onto the branch that is checked out, resets the worktree once to
+// match. It is a no-op for the worktree backend.
+func (g *GitOperations) Finish() error {
+	if err := g.PublishRefs(); err != nil || g.objects == nil || g.bare {
+		return err
+	}
+
+	head, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+	target := g.objects.target
+	checkedOut := target == plumbing.HEAD || (head.Type() == plumbing.SymbolicReference && head.Target() == target)
+	tip := g.objects.current()
+	if !checkedOut || tip.parent.IsZero() || tip.parent == g.objects.worktree {
+		return nil
+	}
+
+	// The worktree was clean when the repository was opened, so this only
+	// brings it up to date with the new commits
+	w, err := g.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: tip.parent, Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to update worktree: %w", err)
+	}
+	g.objects.worktree = tip.parent
+
+	return nil
+}
+
+// objectTipOf returns the in-memory tip of a ref, loading it from the
+// repository the first time it is used
+func (g *GitOperations) objectTipOf(name plumbing.ReferenceName) (*objectTip, error) {
+	if tip, ok := g.objects.tips[name]; ok {
+		return tip, nil
+	}
+
+	ref, err := g.repo.Reference(name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := g.commitTree(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	tip := &objectTip{parent: ref.Hash(), tree: tree}
+	g.objects.tips[name] = tip
+	g.objects.start[name] = ref.Hash()
+	return tip, nil
+}
+
+// objectCheckoutBranch targets branch without touching HEAD or the worktree
+func (g *GitOperations) objectCheckoutBranch(branch, baseRef string) (bool, error) {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+
+	_, err := g.objectTipOf(branchRef)
+	switch {
+	case err == nil:
+		g.objects.target = branchRef
+		return false, nil
+	case err != plumbing.ErrReferenceNotFound:
+		return false, fmt.Errorf("failed to read branch %s: %w", branch, err)
+	}
+
+	base := g.objects.current().parent
+	if baseRef != "" {
+		hash, err := g.repo.ResolveRevision(plumbing.Revision(baseRef))
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve base ref %s: %w", baseRef, err)
+		}
+		base = *hash
+	}
+
+	tree, err := g.commitTree(base)
+	if err != nil {
+		return false, err
+	}
+
+	g.objects.tips[branchRef] = &objectTip{parent: base, tree: tree}
+	g.objects.start[branchRef] = plumbing.ZeroHash
+	g.objects.target = branchRef
+	return true, nil
+}
+
+// objectCheckoutOrphan targets a new branch whose first commit has no parent
+// and starts from the current tree
+func (g *GitOperations) objectCheckoutOrphan(branch string) error {
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if _, err := g.repo.Reference(branchRef, false); err == nil {
+		return fmt.Errorf("branch %s already exists", branch)
+	}
+
+	g.objects.tips[branchRef] = &objectTip{tree: g.objects.current().tree}
+	g.objects.start[branchRef] = plumbing.ZeroHash
+	g.objects.target = branchRef
+	return nil
+}
+
+// objectHead returns the targeted branch and the commit new commits build on
+func (g *GitOperations) objectHead() (string, plumbing.Hash) {
+	if !g.objects.target.IsBranch() {
+		return "", g.objects.current().parent
+	}
+	return g.objects.target.Short(), g.objects.current().parent
+}
+
+// objectReadFile reads a file as of the commit being built
+func (g *GitOperations) objectReadFile(filePath string) (string, error) {
+	name := toTreePath(filePath)
+	if content, ok := g.objects.pending[name]; ok {
+		return content, nil
+	}
+	if g.objects.removed[name] {
+		return "", fmt.Errorf("failed to read file %s: file was deleted", filePath)
+	}
+
+	tree, err := g.repo.TreeObject(g.objects.current().tree)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	file, err := tree.File(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	return content, nil
+}
+
+// objectModifyFile records new content for the next commit
+func (g *GitOperations) objectModifyFile(filePath string, newContent string) {
+	name := toTreePath(filePath)
+	g.objects.pending[name] = newContent
+	delete(g.objects.removed, name)
+}
+
+// objectDeleteFile records the removal of a file for the next commit
+func (g *GitOperations) objectDeleteFile(filePath string) {
+	name := toTreePath(filePath)
+	g.objects.removed[name] = true
+	delete(g.objects.pending, name)
+}
+
+// objectCreateCommit writes the pending changes to files as a new commit on
+// top of the previous one
+func (g *GitOperations) objectCreateCommit(message string, files []string, timestamp *time.Time) (plumbing.Hash, error) {
+	tip := g.objects.current()
+	tree := tip.tree
+	for _, file := range files {
+		name := toTreePath(file)
+		content, ok := g.objects.pending[name]
+		if !ok && !g.objects.removed[name] {
+			continue
+		}
+
+		// A zero blob removes the file from the tree
+		var blob plumbing.Hash
+		var err error
+		if ok {
+			blob, err = g.storeBlob([]byte(content))
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+		}
+
+		tree, err = g.writeTreeChange(tree, strings.Split(name, "/"), blob, filemode.Regular)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to stage file %s: %w", file, err)
+		}
+	}
+
+	when := time.Now()
+	if timestamp != nil {
+		when = *timestamp
+	}
+
+	commit := &object.Commit{
+		Author:    devMetricsSignature(when),
+		Committer: devMetricsSignature(when),
+		Message:   message,
+		TreeHash:  tree,
+	}
+	if !tip.parent.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{tip.parent}
+	}
+
+	hash, err := g.storeObject(commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	for _, file := range files {
+		delete(g.objects.pending, toTreePath(file))
+		delete(g.objects.removed, toTreePath(file))
+	}
+	tip.parent = hash
+	tip.tree = tree
+	return hash, nil
+}
+
+// objectFiles lists every file in the tree being built
+func (g *GitOperations) objectFiles() ([]string, error) {
+	tree, err := g.repo.TreeObject(g.objects.current().tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	var files []string
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode.IsFile() {
+			files = append(files, f.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return files, nil
+}
+
+// commitTree returns the tree of a commit
+func (g *GitOperations) commitTree(hash plumbing.Hash) (plumbing.Hash, error) {
+	commit, err := g.repo.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	return commit.TreeHash, nil
+}
+
+// writeTreeChange stores a copy of the tree with the file at path set to blob
+// and returns the new tree's hash. A zero blob removes the file. Only trees
+// along the path are rewritten.
+func (g *GitOperations) writeTreeChange(treeHash plumbing.Hash, path []string, blob plumbing.Hash, mode filemode.FileMode) (plumbing.Hash, error) {
+	entries, err := g.updateTreeEntries(treeHash, path, blob, mode)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.storeObject(&object.Tree{Entries: entries})
+}
+
+// updateTreeEntries returns the entries of the tree with the change applied,
+// writing any changed subtrees and dropping subtrees left empty
+func (g *GitOperations) updateTreeEntries(treeHash plumbing.Hash, path []string, blob plumbing.Hash, mode filemode.FileMode) ([]object.TreeEntry, error) {
+	var entries []object.TreeEntry
+	if !treeHash.IsZero() {
+		tree, err := g.repo.TreeObject(treeHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tree: %w", err)
+		}
+		entries = append(entries, tree.Entries...)
+	}
+
+	name := path[0]
+	index := -1
+	for i, entry := range entries {
+		if entry.Name == name {
+			index = i
+			break
+		}
+	}
+
+	var updated *object.TreeEntry
+	if len(path) == 1 {
+		if !blob.IsZero() {
+			// Keep the mode of existing files so executables stay executable
+			if index >= 0 && entries[index].Mode.IsFile() {
+				mode = entries[index].Mode
+			}
+			updated = &object.TreeEntry{Name: name, Mode: mode, Hash: blob}
+		}
+	} else {
+		child := plumbing.ZeroHash
+		if index >= 0 && entries[index].Mode == filemode.Dir {
+			child = entries[index].Hash
+		}
+		childEntries, err := g.updateTreeEntries(child, path[1:], blob, mode)
+		if err != nil {
+			return nil, err
+		}
+		if len(childEntries) > 0 {
+			childHash, err := g.storeObject(&object.Tree{Entries: childEntries})
+			if err != nil {
+				return nil, err
+			}
+			updated = &object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: childHash}
+		}
+	}
+
+	switch {
+	case updated != nil && index >= 0:
+		entries[index] = *updated
+	case updated != nil:
+		entries = append(entries, *updated)
+	case index >= 0:
+		entries = append(entries[:index], entries[index+1:]...)
+	}
+
+	sortTreeEntries(entries)
+	return entries, nil
+}
+
+// sortTreeEntries orders entries the way git does, comparing directories as
+// if their names ended in a slash
+func sortTreeEntries(entries []object.TreeEntry) {
+	key := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return key(entries[i]) < key(entries[j]) })
+}
+
+func toTreePath(filePath string) string {
+	return filepath.ToSlash(filePath)
+}
+
+var errBareWorktree = errors.New("bare repositories have no worktree, use the objects backend")
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrTruncated is returned for LLM output that stops partway, usually
+// because the response ran into the token limit
+var ErrTruncated = errors.New("output looks truncated")
+
+// DefaultMaxChangeRatio is the share of a file's lines a single change may
+// touch unless configured otherwise
+const DefaultMaxChangeRatio = 0.5
+
+// DefaultOutputAttempts is how many times a file is asked for before it is
+// skipped unless configured otherwise
+const DefaultOutputAttempts = 3
+
+// Changes touching this few lines are never rejected for their ratio, so
+// small files can still be edited
+const minRatioCheckedLines = 5
+
+// chattyPreambles are how models tend to introduce a file they were asked to
+// return on its own
+var chattyPreambles = []string{"here is", "here's", "sure", "certainly", "below is"}
+
+// StripFences returns the file content in a response, dropping markdown code
+// fences and any text around them
+func StripFences(response string) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+
+	start := -1
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		if start < 0 {
+			start = i
+			continue
+		}
+		return strings.Join(lines[start+1:i], "\n") + "\n", nil
+	}
+	if start >= 0 {
+		return "", fmt.Errorf("%w: code fence is never closed", ErrTruncated)
+	}
+
+	// Without fences only an introductory line can be recognised
+	if len(lines) > 1 {
+		first := strings.ToLower(strings.TrimSpace(lines[0]))
+		for _, preamble := range chattyPreambles {
+			if strings.HasPrefix(first, preamble) && strings.HasSuffix(first, ":") {
+				return strings.TrimLeft(strings.Join(lines[1:], "\n"), "\n"), nil
+			}
+		}
+	}
+	return response, nil
+}
+
+// CheckOutput decides whether generated content can be written to filePath.
+// original is the file's content before the change, empty for new files.
+// Formats that parsed before the change must still parse, brackets must stay
+// balanced and a change may touch at most maxChangeRatio of the file's lines.
+func CheckOutput(filePath, original, modified string, maxChangeRatio float64) error {
+	if strings.TrimSpace(modified) == "" {
+		return errors.New("output is empty")
+	}
+
+	if parse := formatParser(filePath); parse != nil {
+		if original == "" || parse(original) == nil {
+			if err := parse(modified); err != nil {
+				return fmt.Errorf("output does not parse: %w", err)
+			}
+		}
+	}
+
+	if bracketsMatter(filePath) && bracketBalance(modified) != bracketBalance(original) {
+		return fmt.Errorf("%w: brackets are unbalanced", ErrTruncated)
+	}
+
+	if original != "" && maxChangeRatio > 0 {
+		total := strings.Count(strings.TrimSuffix(original, "\n"), "\n") + 1
+		added, removed := changedLines(original, modified)
+		touched := added
+		if removed > touched {
+			touched = removed
+		}
+		if touched > minRatioCheckedLines && float64(touched) > maxChangeRatio*float64(total) {
+			return fmt.Errorf("change touches %d of %d lines, more than the allowed %.0f%%",
+				touched, total, maxChangeRatio*100)
+		}
+	}
+
+	return nil
+}
+
+// formatParser returns a function that reports whether content is valid in
+// the format of filePath, or nil if the format is not checked
+func formatParser(filePath string) func(content string) error {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".go":
+		return func(content string) error {
+			_, err := parser.ParseFile(token.NewFileSet(), filePath, content, parser.AllErrors)
+			return err
+		}
+	case ".json":
+		return func(content string) error {
+			var value any
+			return json.Unmarshal([]byte(content), &value)
+		}
+	case ".yaml", ".yml":
+		return func(content string) error {
+			decoder := yaml.NewDecoder(strings.NewReader(content))
+			for {
+				var value any
+				err := decoder.Decode(&value)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bracketsMatter reports whether brackets in filePath are expected to
+// balance, which is how truncated code is recognised in languages we cannot
+// parse
+func bracketsMatter(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".go", ".js", ".jsx", ".ts", ".tsx", ".java", ".c", ".h", ".cc", ".cpp", ".hpp",
+		".cs", ".rs", ".swift", ".kt", ".scala", ".php", ".dart", ".py", ".rb", ".json", ".css", ".scss":
+		return true
+	}
+	return false
+}
+
+// bracketBalance returns how many brackets are opened but not closed
+func bracketBalance(content string) int {
+	balance := 0
+	for _, r := range content {
+		switch r {
+		case '(', '[', '{':
+			balance++
+		case ')', ']', '}':
+			balance--
+		}
+	}
+	return balance
+}
+
+// changedLines counts the lines only in modified and only in original,
+// ignoring lines that merely moved
+func changedLines(original, modified string) (int, int) {
+	counts := make(map[string]int)
+	for _, line := range strings.Split(original, "\n") {
+		counts[line]++
+	}
+	added := 0
+	for _, line := range strings.Split(modified, "\n") {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added++
+		}
+	}
+	removed := 0
+	for _, n := range counts {
+		removed += n
+	}
+	return added, removed
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckOutput(t *testing.T) {
+	const goFile = "package main\n\nfunc main() {\n\tprintln(\"a\")\n}\n"
+	long := strings.Repeat("line\n", 20)
+	tests := []struct {
+		name     string
+		path     string
+		original string
+		modified string
+		ratio    float64
+		err      error // expected error, nil for success
+		anyErr   bool  // any error is expected
+	}{
+		{
+			name:     "valid go edit",
+			path:     "main.go",
+			original: goFile,
+			modified: strings.Replace(goFile, `"a"`, `"b"`, 1),
+			ratio:    0.5,
+		},
+		{
+			name:     "empty output",
+			path:     "main.go",
+			original: goFile,
+			modified: " \n\n",
+			ratio:    0.5,
+			anyErr:   true,
+		},
+		{
+			name:     "go that no longer parses",
+			path:     "main.go",
+			original: goFile,
+			modified: strings.Replace(goFile, "func main()", "func main(", 1) + ")\n",
+			ratio:    0.5,
+			anyErr:   true,
+		},
+		{
+			name:     "go that did not parse before",
+			path:     "main.go",
+			original: "package main\n\nfunc (\n)\n",
+			modified: "package main\n\nfunc ( x\n)\n",
+			ratio:    0.5,
+		},
+		{
+			name:     "new go file must parse",
+			path:     "new.go",
+			modified: "package main\n\nfunc broken( {\n}\n)\n",
+			anyErr:   true,
+		},
+		{
+			name:     "truncated javascript",
+			path:     "app.js",
+			original: "function a() {\n  return 1;\n}\n",
+			modified: "function a() {\n  return 2;\n",
+			ratio:    0.9,
+			err:      ErrTruncated,
+		},
+		{
+			name:     "brackets in markdown are not counted",
+			path:     "README.md",
+			original: "# Title\n",
+			modified: "# Title (draft\n",
+			ratio:    0.9,
+		},
+		{
+			name:     "invalid json",
+			path:     "data.json",
+			original: "{\"a\": 1}\n",
+			modified: "{\"a\": 1,}\n",
+			ratio:    0.9,
+			anyErr:   true,
+		},
+		{
+			name:     "invalid yaml",
+			path:     "config.yml",
+			original: "a: 1\n",
+			modified: "a: [1\n",
+			ratio:    0.9,
+			anyErr:   true,
+		},
+		{
+			name:     "change touching too many lines",
+			path:     "notes.txt",
+			original: long,
+			modified: strings.Repeat("other\n", 20),
+			ratio:    0.5,
+			anyErr:   true,
+		},
+		{
+			name:     "change within the ratio",
+			path:     "notes.txt",
+			original: long,
+			modified: strings.Repeat("line\n", 14) + strings.Repeat("other\n", 6),
+			ratio:    0.5,
+		},
+		{
+			name:     "small change to a small file",
+			path:     "notes.txt",
+			original: "a\nb\n",
+			modified: "c\nd\ne\n",
+			ratio:    0.1,
+		},
+		{
+			name:     "zero ratio disables the check",
+			path:     "notes.txt",
+			original: long,
+			modified: strings.Repeat("other\n", 20),
+		},
+		{
+			name:     "moved lines are not changes",
+			path:     "notes.txt",
+			original: "a\nb\nc\nd\ne\nf\ng\nh\n",
+			modified: "h\ng\nf\ne\nd\nc\nb\na\n",
+			ratio:    0.1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckOutput(tt.path, tt.original, tt.modified, tt.ratio)
+			switch {
+			case tt.err != nil || tt.anyErr:
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("CheckOutput() error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("CheckOutput() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStripFences(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+		err      error
+	}{
+		{"plain content", "a\nb\n", "a\nb\n", nil},
+		{"fenced with text around", "Here you go:\n```go\na\nb\n```\nDone.", "a\nb\n", nil},
+		{"chatty preamble", "Here is the updated file:\na\nb\n", "a\nb\n", nil},
+		{"unclosed fence", "```go\na\nb\n", "", ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StripFences(tt.response)
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("StripFences() error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("StripFences() error = %v", err)
+			case got != tt.want:
+				t.Errorf("StripFences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
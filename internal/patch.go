@@ -0,0 +1,421 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ErrNoEdits is returned when an LLM response contains no diff hunks or
+// search/replace blocks
+var ErrNoEdits = errors.New("response contains no edits")
+
+// edit replaces one run of lines in a file. Hunks from a unified diff know
+// roughly where they apply, search/replace blocks only what they replace.
+type edit struct {
+	old  []string
+	new  []string
+	keep []int // for each new line of a hunk, the old line it repeats as context or -1
+	line int   // 0-based line the hunk claims to start at, -1 for search/replace blocks
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+const (
+	searchMarker  = "<<<<<<< SEARCH"
+	dividerMarker = "======="
+	replaceMarker = ">>>>>>> REPLACE"
+)
+
+// ApplyPatch applies the unified diff or search/replace blocks in response to
+// content. Edits are located by exact match first and then ignoring
+// whitespace; the whole patch is rejected if any edit cannot be placed.
+func ApplyPatch(content, response string) (string, error) {
+	var edits []edit
+	var err error
+	if strings.Contains(response, searchMarker) {
+		edits, err = parseSearchReplace(response)
+	} else {
+		edits, err = parseUnifiedDiff(response)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(edits) == 0 {
+		return "", ErrNoEdits
+	}
+
+	lines := strings.Split(content, "\n")
+	next := 0 // edits apply in order, so search after the previous one
+	for i, e := range edits {
+		at, err := locate(lines, e, next)
+		if err != nil {
+			return "", fmt.Errorf("edit %d of %d: %w", i+1, len(edits), err)
+		}
+
+		result := make([]string, 0, len(lines)-len(e.old)+len(e.new))
+		result = append(result, lines[:at]...)
+		for j, line := range e.new {
+			// Context keeps the file's own whitespace when it matched loosely
+			if e.keep != nil && e.keep[j] >= 0 {
+				line = lines[at+e.keep[j]]
+			}
+			result = append(result, line)
+		}
+		result = append(result, lines[at+len(e.old):]...)
+		lines = result
+		next = at + len(e.new)
+	}
+
+	patched := strings.Join(lines, "\n")
+	if patched == content {
+		return "", ErrNoEdits
+	}
+	return patched, nil
+}
+
+// matches returns every line from line from onwards where the edit's old
+// lines match
+func (e edit) matches(lines []string, from int, same func(a, b string) bool) []int {
+	var matches []int
+	for at := from; at+len(e.old) <= len(lines); at++ {
+		if matchesAt(lines, e.old, at, same) {
+			matches = append(matches, at)
+		}
+	}
+	return matches
+}
+
+// locate finds the line an edit applies at. Search/replace blocks must match
+// exactly one place, hunks take the match nearest to their line number.
+func locate(lines []string, e edit, from int) (int, error) {
+	if len(e.old) == 0 {
+		// Pure insertions can only be placed by their line number
+		if e.line < 0 {
+			return 0, errors.New("search block is empty")
+		}
+		at := e.line
+		if at < from {
+			at = from
+		}
+		return min(at, len(lines)), nil
+	}
+
+	for _, same := range []func(a, b string) bool{sameLine, sameIgnoringSpace} {
+		matches := e.matches(lines, from, same)
+		if len(matches) == 0 {
+			continue
+		}
+		if e.line < 0 {
+			if len(matches) > 1 {
+				return 0, fmt.Errorf("search block matches %d places: %s", len(matches), strings.TrimSpace(e.old[0]))
+			}
+			return matches[0], nil
+		}
+		// A hunk's line number may be off, take the nearest match
+		nearest := matches[0]
+		for _, at := range matches[1:] {
+			if abs(at-e.line) < abs(nearest-e.line) {
+				nearest = at
+			}
+		}
+		return nearest, nil
+	}
+	return 0, fmt.Errorf("no match for: %s", strings.TrimSpace(e.old[0]))
+}
+
+func matchesAt(lines, want []string, at int, same func(a, b string) bool) bool {
+	for i, line := range want {
+		if !same(lines[at+i], line) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameLine(a, b string) bool {
+	return strings.TrimRight(a, " \t\r") == strings.TrimRight(b, " \t\r")
+}
+
+func sameIgnoringSpace(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// parseUnifiedDiff reads the hunks of a unified diff, ignoring file headers
+// and any text or code fences around it
+func parseUnifiedDiff(response string) ([]edit, error) {
+	var edits []edit
+	var current *edit
+	remaining := 0      // old lines the current hunk header announced but we have not seen
+	added := 0          // the same for new lines
+	lastContext := true // whether the current hunk's last line was context
+	lastOp := byte(' ') // what the current hunk's last line was
+	oldEOF, newEOF := false, false
+	fences := 0
+
+	// Lines are split on newlines, so a file ending in one has an empty last
+	// line. A hunk that adds or removes the final newline must add or remove
+	// that empty line too.
+	flush := func() {
+		switch {
+		case oldEOF && !newEOF:
+			current.new = append(current.new, "")
+			current.keep = append(current.keep, -1)
+		case newEOF && !oldEOF:
+			current.old = append(current.old, "")
+		}
+		edits = append(edits, *current)
+		current = nil
+	}
+
+	response = strings.TrimSuffix(strings.ReplaceAll(response, "\r\n", "\n"), "\n")
+	for _, line := range strings.Split(response, "\n") {
+		if strings.HasPrefix(line, "```") {
+			fences++
+		}
+		if match := hunkHeader.FindStringSubmatch(line); match != nil {
+			if current != nil {
+				flush()
+			}
+			start, _ := strconv.Atoi(match[1])
+			remaining, added = hunkCount(match[2]), hunkCount(match[3])
+			// An empty range names the line before the insertion
+			line := start - 1
+			if remaining == 0 {
+				line = start
+			}
+			current = &edit{line: line}
+			lastContext = true
+			oldEOF, newEOF = false, false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		// Lines that look like file headers are content while the hunk
+		// still expects lines
+		header := strings.HasPrefix(line, "diff ") || strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ")
+		switch {
+		case strings.HasPrefix(line, "```"), header && remaining <= 0 && added <= 0:
+			flush()
+		case strings.HasPrefix(line, `\`):
+			// "\ No newline at end of file" after the last line of a side
+			oldEOF = oldEOF || lastOp != '+'
+			newEOF = newEOF || lastOp != '-'
+		case strings.HasPrefix(line, "+"):
+			current.new = append(current.new, line[1:])
+			current.keep = append(current.keep, -1)
+			added--
+			lastContext = false
+			lastOp = '+'
+		case strings.HasPrefix(line, "-"):
+			current.old = append(current.old, line[1:])
+			remaining--
+			lastContext = false
+			lastOp = '-'
+		case strings.HasPrefix(line, " "), line == "" && remaining > 0:
+			// Models often drop the space in front of empty context lines
+			text := strings.TrimPrefix(line, " ")
+			current.keep = append(current.keep, len(current.old))
+			current.old = append(current.old, text)
+			current.new = append(current.new, text)
+			remaining--
+			added--
+			lastContext = true
+			lastOp = ' '
+		default:
+			flush()
+		}
+	}
+	if fences%2 == 1 {
+		return nil, fmt.Errorf("%w: code fence is never closed", ErrTruncated)
+	}
+	if current != nil {
+		// Miscounted hunks still end in context, cut off ones usually do not
+		if remaining > 0 && !lastContext {
+			return nil, fmt.Errorf("%w: last hunk ends early", ErrTruncated)
+		}
+		flush()
+	}
+
+	for _, e := range edits {
+		if len(e.old) == 0 && len(e.new) == 0 {
+			return nil, errors.New("diff contains an empty hunk")
+		}
+	}
+	return edits, nil
+}
+
+// parseSearchReplace reads blocks of the form
+//
+//	<<<<<<< SEARCH
+//	lines to find
+//	=======
+//	lines to put in their place
+//	>>>>>>> REPLACE
+func parseSearchReplace(response string) ([]edit, error) {
+	var edits []edit
+	var current *edit
+	inReplace := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(response, "\r\n", "\n"), "\n") {
+		switch strings.TrimSpace(line) {
+		case searchMarker:
+			if current != nil {
+				return nil, errors.New("search block is not closed")
+			}
+			current = &edit{line: -1}
+			inReplace = false
+			continue
+		case dividerMarker:
+			if current != nil && !inReplace {
+				inReplace = true
+				continue
+			}
+		case replaceMarker:
+			if current == nil || !inReplace {
+				return nil, errors.New("replace marker without a search block")
+			}
+			edits = append(edits, *current)
+			current = nil
+			continue
+		}
+
+		switch {
+		case current == nil:
+		case inReplace:
+			current.new = append(current.new, line)
+		default:
+			current.old = append(current.old, line)
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("%w: search block is never closed", ErrTruncated)
+	}
+	return edits, nil
+}
+
+// UnifiedDiff returns a git style unified diff turning original into
+// modified, with three lines of context around each change. An empty
+// original is a new file and an empty modified a deleted one.
+func UnifiedDiff(filePath, original, modified string) string {
+	if original == modified {
+		return ""
+	}
+
+	patch := textPatch{path: filePath, from: original, to: modified}
+	var out strings.Builder
+	// Writing to a strings.Builder never fails
+	fdiff.NewUnifiedEncoder(&out, fdiff.DefaultContextLines).Encode(patch)
+	return out.String()
+}
+
+// textPatch is the change of a single text file, in the form go-git's
+// unified diff encoder takes
+type textPatch struct {
+	path     string
+	from, to string
+}
+
+func (p textPatch) FilePatches() []fdiff.FilePatch { return []fdiff.FilePatch{p} }
+func (p textPatch) Message() string                { return "" }
+func (p textPatch) IsBinary() bool                 { return false }
+
+func (p textPatch) Files() (fdiff.File, fdiff.File) {
+	var from, to fdiff.File
+	if p.from != "" {
+		from = textFile{path: p.path, content: p.from}
+	}
+	if p.to != "" {
+		to = textFile{path: p.path, content: p.to}
+	}
+	return from, to
+}
+
+func (p textPatch) Chunks() []fdiff.Chunk {
+	var chunks []fdiff.Chunk
+	for _, d := range diff.Do(p.from, p.to) {
+		op := fdiff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			op = fdiff.Delete
+		case diffmatchpatch.DiffInsert:
+			op = fdiff.Add
+		}
+		chunks = append(chunks, textChunk{content: d.Text, op: op})
+	}
+	return chunks
+}
+
+type textFile struct {
+	path    string
+	content string
+}
+
+func (f textFile) Hash() plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(f.content))
+}
+func (f textFile) Mode() filemode.FileMode { return filemode.Regular }
+func (f textFile) Path() string            { return f.path }
+
+type textChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c textChunk) Content() string       { return c.content }
+func (c textChunk) Type() fdiff.Operation { return c.op }
+
+// DiffStats counts the lines a unified diff adds and removes. Only lines
+// inside hunks count, so content that looks like a file header is counted
+// like any other.
+func DiffStats(diff string) (int, int) {
+	added, removed := 0, 0
+	oldLeft, newLeft := 0, 0 // lines the current hunk header announced but we have not seen
+	for _, line := range strings.Split(diff, "\n") {
+		if oldLeft <= 0 && newLeft <= 0 {
+			if match := hunkHeader.FindStringSubmatch(line); match != nil {
+				oldLeft, newLeft = hunkCount(match[2]), hunkCount(match[3])
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			added++
+			newLeft--
+		case strings.HasPrefix(line, "-"):
+			removed++
+			oldLeft--
+		case strings.HasPrefix(line, " "):
+			oldLeft--
+			newLeft--
+		}
+	}
+	return added, removed
+}
+
+// hunkCount reads the line count of a hunk range, which is 1 when left out
+func hunkCount(count string) int {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(count)
+	return n
+}
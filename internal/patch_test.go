@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// randomText builds a file from a few lines that repeat, so diffs have
+// ambiguous context, including lines that look like diff headers
+func randomText(rng *rand.Rand) string {
+	words := []string{"a", "b", "c", "", "-- x", "++ y", "--- z", "+++ w", "  indented", "\\ back"}
+	lines := make([]string, 1+rng.Intn(30))
+	for i := range lines {
+		lines[i] = words[rng.Intn(len(words))]
+	}
+	text := strings.Join(lines, "\n")
+	if rng.Intn(3) > 0 {
+		text += "\n"
+	}
+	return text
+}
+
+// mutate changes, inserts and removes random lines of text and sometimes
+// adds or drops its final newline
+func mutate(rng *rand.Rand, text string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for n := 1 + rng.Intn(4); n > 0; n-- {
+		at := rng.Intn(len(lines) + 1)
+		switch rng.Intn(3) {
+		case 0:
+			lines = append(lines[:at], append([]string{"new"}, lines[at:]...)...)
+		case 1:
+			if at < len(lines) && len(lines) > 1 {
+				lines = append(lines[:at], lines[at+1:]...)
+			}
+		default:
+			if at < len(lines) {
+				lines[at] = "changed"
+			}
+		}
+	}
+	modified := strings.Join(lines, "\n")
+	if strings.HasSuffix(text, "\n") != (rng.Intn(4) == 0) {
+		modified += "\n"
+	}
+	return modified
+}
+
+func TestUnifiedDiffRoundTrip(t *testing.T) {
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	dir := t.TempDir()
+	for i := 0; i < 300; i++ {
+		original := randomText(rng)
+		modified := mutate(rng, original)
+		// Empty files diff as created or deleted ones
+		if modified == original || original == "" || modified == "" {
+			continue
+		}
+		diff := UnifiedDiff("file.txt", original, modified)
+
+		patched, err := ApplyPatch(original, diff)
+		if err != nil || patched != modified {
+			t.Fatalf("case %d: ApplyPatch() = %q, %v, want %q\n%s", i, patched, err, modified, diff)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(original), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(git, "apply", "-")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader(diff)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("case %d: git apply: %v: %s\n%s", i, err, out, diff)
+		}
+		applied, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(applied) != modified {
+			t.Fatalf("case %d: git apply gave %q, want %q\n%s", i, applied, modified, diff)
+		}
+	}
+}
+
+func TestDiffStats(t *testing.T) {
+	original := "keep\n-- comment\nold\n++ counter\n"
+	modified := "keep\n-- comment\nnew\n--- header-like\n++ counter\n"
+
+	added, removed := DiffStats(UnifiedDiff("file.sql", original, modified))
+	if added != 2 || removed != 1 {
+		t.Errorf("DiffStats() = +%d -%d, want +2 -1", added, removed)
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	const content = "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 1\n}\n"
+	tests := []struct {
+		name     string
+		content  string
+		response string
+		want     string
+		err      error // expected error, nil for success
+		anyErr   bool  // any error is expected
+	}{
+		{
+			name:     "exact hunk",
+			content:  content,
+			response: "@@ -5,3 +5,3 @@\n func b() {\n-\treturn 1\n+\treturn 2\n }\n",
+			want:     "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n",
+		},
+		{
+			name:     "offset hunk takes the nearest match",
+			content:  content,
+			response: "@@ -7,2 +7,2 @@\n-\treturn 1\n+\treturn 2\n }\n",
+			want:     "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 2\n}\n",
+		},
+		{
+			name:     "hunk far off still finds its only match",
+			content:  content,
+			response: "@@ -40,3 +40,3 @@\n func a() {\n-\treturn 1\n+\treturn 0\n }\n",
+			want:     "func a() {\n\treturn 0\n}\n\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "whitespace-only mismatch keeps the file's whitespace",
+			content:  content,
+			response: "@@ -1,3 +1,3 @@\n func a()  {\n-    return 1\n+\treturn 3\n }\n",
+			want:     "func a() {\n\treturn 3\n}\n\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "empty context line without its space",
+			content:  content,
+			response: "@@ -3,3 +3,4 @@\n }\n\n+// b does b\n func b() {\n",
+			want:     "func a() {\n\treturn 1\n}\n\n// b does b\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "content lines that look like file headers",
+			content:  "-- first\nkeep\n",
+			response: "--- a/q.sql\n+++ b/q.sql\n@@ -1,2 +1,2 @@\n--- first\n+++ second\n keep\n",
+			want:     "++ second\nkeep\n",
+		},
+		{
+			name:     "file headers end a hunk",
+			content:  content,
+			response: "@@ -1,2 +1,2 @@\n func a() {\n-\treturn 1\n+\treturn 4\n--- a/other.go\n+++ b/other.go\n",
+			want:     "func a() {\n\treturn 4\n}\n\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "diff inside a closed code fence",
+			content:  content,
+			response: "Here it is:\n```diff\n@@ -1,2 +1,2 @@\n func a() {\n-\treturn 1\n+\treturn 5\n```\nDone.",
+			want:     "func a() {\n\treturn 5\n}\n\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "unclosed code fence",
+			content:  content,
+			response: "```diff\n@@ -1,2 +1,2 @@\n func a() {\n-\treturn 1\n+\treturn 5\n",
+			err:      ErrTruncated,
+		},
+		{
+			name:     "final hunk cut off after a change",
+			content:  content,
+			response: "@@ -1,3 +1,3 @@\n func a() {\n-\treturn 1\n",
+			err:      ErrTruncated,
+		},
+		{
+			name:     "miscounted final hunk ending in context",
+			content:  content,
+			response: "@@ -1,5 +1,5 @@\n func a() {\n-\treturn 1\n+\treturn 6\n }\n",
+			want:     "func a() {\n\treturn 6\n}\n\nfunc b() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "hunk that matches nowhere",
+			content:  content,
+			response: "@@ -1,2 +1,2 @@\n func c() {\n-\treturn 1\n+\treturn 2\n",
+			anyErr:   true,
+		},
+		{
+			name:     "search/replace block",
+			content:  content,
+			response: "<<<<<<< SEARCH\nfunc b() {\n\treturn 1\n=======\nfunc b() {\n\treturn 7\n>>>>>>> REPLACE\n",
+			want:     "func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 7\n}\n",
+		},
+		{
+			name:     "ambiguous search block",
+			content:  content,
+			response: "<<<<<<< SEARCH\n\treturn 1\n}\n=======\n\treturn 8\n}\n>>>>>>> REPLACE\n",
+			anyErr:   true,
+		},
+		{
+			name:     "search block matching loosely",
+			content:  content,
+			response: "<<<<<<< SEARCH\nfunc   b() {\n=======\nfunc b2() {\n>>>>>>> REPLACE\n",
+			want:     "func a() {\n\treturn 1\n}\n\nfunc b2() {\n\treturn 1\n}\n",
+		},
+		{
+			name:     "unclosed search block",
+			content:  content,
+			response: "<<<<<<< SEARCH\nfunc b() {\n=======\nfunc b2() {\n",
+			err:      ErrTruncated,
+		},
+		{
+			name:     "no edits",
+			content:  content,
+			response: "Looks good to me.",
+			err:      ErrNoEdits,
+		},
+		{
+			name:     "edit that changes nothing",
+			content:  content,
+			response: "@@ -1,1 +1,1 @@\n-func a() {\n+func a() {\n",
+			err:      ErrNoEdits,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyPatch(tt.content, tt.response)
+			switch {
+			case tt.err != nil || tt.anyErr:
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Fatalf("ApplyPatch() error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Fatalf("ApplyPatch() error = %v", err)
+			case got != tt.want:
+				t.Errorf("ApplyPatch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// History policies decide what happens when a plan starts before the commit
+// it would be built on
+const (
+	HistoryRefuse = "refuse" // fail instead of creating out-of-order history
+	HistoryOrphan = "orphan" // start a new branch with no parents
+	HistoryShift  = "shift"  // move the schedule between the base commit and now
+)
+
+// minSqueezedSpacing is how close together squeezing a schedule may bring two
+// commits
+const minSqueezedSpacing = time.Minute
+
+// Plan is a serializable schedule of commits that can be reviewed before it
+// is applied to any repository
+type Plan struct {
+	CreatedAt    time.Time        `json:"created_at"`
+	Persona      string           `json:"persona,omitempty"`
+	Repositories []RepositoryPlan `json:"repositories"`
+}
+
+// RepositoryPlan holds the planned commits for a single repository
+type RepositoryPlan struct {
+	Path    string          `json:"path"`
+	Branch  string          `json:"branch,omitempty"`
+	BaseRef string          `json:"base_ref,omitempty"`
+	Backend string          `json:"backend,omitempty"`
+	Commits []PlannedCommit `json:"commits"`
+}
+
+// HasTopicBranches reports whether any planned commit goes on a topic branch
+func (p *RepositoryPlan) HasTopicBranches() bool {
+	for _, commit := range p.Commits {
+		if commit.Pattern.Branch != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// PlannedCommit pairs a commit pattern with the files chosen for it
+type PlannedCommit struct {
+	Pattern CommitPattern `json:"pattern"`
+	Files   []string      `json:"files"` // existing files to modify
+	Added   []NewFile     `json:"added,omitempty"`
+	Deleted []string      `json:"deleted,omitempty"`
+	Renamed []FileRename  `json:"renamed,omitempty"`
+}
+
+// Paths returns every path the commit touches, including both sides of
+// renames
+func (c PlannedCommit) Paths() []string {
+	paths := append([]string{}, c.Files...)
+	for _, added := range c.Added {
+		paths = append(paths, added.Path)
+	}
+	paths = append(paths, c.Deleted...)
+	for _, rename := range c.Renamed {
+		paths = append(paths, rename.From, rename.To)
+	}
+	return paths
+}
+
+// SortCommits orders the planned commits chronologically so commit dates
+// strictly increase along the branch, even for hand-edited plans
+func (p *RepositoryPlan) SortCommits() {
+	sort.SliceStable(p.Commits, func(i, j int) bool {
+		return p.Commits[i].Pattern.Timestamp.Before(p.Commits[j].Pattern.Timestamp)
+	})
+
+	for i := 1; i < len(p.Commits); i++ {
+		prev := p.Commits[i-1].Pattern.Timestamp
+		if !p.Commits[i].Pattern.Timestamp.After(prev) {
+			p.Commits[i].Pattern.Timestamp = prev.Add(time.Second)
+		}
+	}
+}
+
+// StartsAfter reports whether every planned commit is dated after t. The
+// commits must already be sorted.
+func (p *RepositoryPlan) StartsAfter(t time.Time) bool {
+	return len(p.Commits) == 0 || p.Commits[0].Pattern.Timestamp.After(t)
+}
+
+// ShiftBetween moves the schedule so it starts after start and ends no later
+// than end. Moving it forward by whole days keeps each commit's time of day
+// and is used when it is enough, otherwise the schedule is squeezed into the
+// window keeping commits at least a minute apart. It returns the number of
+// days shifted, or whether the schedule was squeezed, and fails without
+// changing anything when the commits do not fit. The commits must already
+// be sorted.
+func (p *RepositoryPlan) ShiftBetween(start, end time.Time) (days int, squeezed bool, err error) {
+	if len(p.Commits) == 0 {
+		return 0, false, nil
+	}
+	first := p.Commits[0].Pattern.Timestamp
+	last := p.Commits[len(p.Commits)-1].Pattern.Timestamp
+
+	days = int(start.Sub(first) / (24 * time.Hour))
+	for !first.AddDate(0, 0, days).After(start) {
+		days++
+	}
+	if !last.AddDate(0, 0, days).After(end) {
+		for i := range p.Commits {
+			p.Commits[i].Pattern.Timestamp = p.Commits[i].Pattern.Timestamp.AddDate(0, 0, days)
+		}
+		return days, false, nil
+	}
+
+	// Squeeze the schedule between a minute after start and end
+	low := start.Add(minSqueezedSpacing)
+	window := end.Sub(low)
+	if window < time.Duration(len(p.Commits)-1)*minSqueezedSpacing {
+		return 0, false, fmt.Errorf("%d commits do not fit between %s and %s",
+			len(p.Commits), start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	span := last.Sub(first)
+	times := make([]time.Time, len(p.Commits))
+	for i, commit := range p.Commits {
+		offset := window
+		if span > 0 {
+			offset = time.Duration(float64(commit.Pattern.Timestamp.Sub(first)) / float64(span) * float64(window))
+		}
+		times[i] = low.Add(offset).In(commit.Pattern.Timestamp.Location())
+		if i > 0 && times[i].Sub(times[i-1]) < minSqueezedSpacing {
+			times[i] = times[i-1].Add(minSqueezedSpacing)
+		}
+	}
+	// Pushing close commits apart may have pushed the last ones past end
+	for i := len(times) - 1; i >= 0; i-- {
+		latest := end
+		if i < len(times)-1 {
+			latest = times[i+1].Add(-minSqueezedSpacing)
+		}
+		if times[i].After(latest) {
+			times[i] = latest.In(times[i].Location())
+		}
+	}
+
+	for i := range p.Commits {
+		p.Commits[i].Pattern.Timestamp = times[i]
+	}
+	return 0, true, nil
+}
+
+// LoadPlan reads a plan from a JSON file
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file: %w", err)
+	}
+
+	if len(plan.Repositories) == 0 {
+		return nil, fmt.Errorf("plan contains no repositories: %s", path)
+	}
+
+	return &plan, nil
+}
+
+// SavePlan writes a plan to a JSON file
+func SavePlan(plan *Plan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+
+	// Write through a temp file so an interrupted save never truncates the plan
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShiftBetween(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, second, 0, time.UTC)
+	}
+	base := at(10, 12, 0, 0)
+	tests := []struct {
+		name     string
+		commits  []time.Time
+		end      time.Time
+		want     []time.Time
+		days     int
+		squeezed bool
+		wantErr  bool
+	}{
+		{
+			name: "no commits",
+			end:  at(20, 0, 0, 0),
+		},
+		{
+			name:    "whole days keep the time of day",
+			commits: []time.Time{at(5, 9, 0, 0), at(7, 15, 30, 0)},
+			end:     at(20, 0, 0, 0),
+			want:    []time.Time{at(11, 9, 0, 0), at(13, 15, 30, 0)},
+			days:    6,
+		},
+		{
+			name:     "squeezed in proportion",
+			commits:  []time.Time{at(5, 9, 0, 0), at(6, 9, 0, 0), at(7, 9, 0, 0)},
+			end:      at(10, 18, 0, 0),
+			want:     []time.Time{at(10, 12, 1, 0), at(10, 15, 0, 30), at(10, 18, 0, 0)},
+			squeezed: true,
+		},
+		{
+			name:     "close commits are kept a minute apart",
+			commits:  []time.Time{at(5, 9, 0, 0), at(5, 9, 0, 1), at(5, 9, 0, 2)},
+			end:      at(10, 12, 3, 0),
+			want:     []time.Time{at(10, 12, 1, 0), at(10, 12, 2, 0), at(10, 12, 3, 0)},
+			squeezed: true,
+		},
+		{
+			name:     "spacing never pushes commits past the end",
+			commits:  []time.Time{at(5, 0, 0, 0), at(5, 9, 59, 59), at(5, 10, 0, 0)},
+			end:      at(10, 12, 3, 0),
+			want:     []time.Time{at(10, 12, 1, 0), at(10, 12, 2, 0), at(10, 12, 3, 0)},
+			squeezed: true,
+		},
+		{
+			name:    "too many commits for the window",
+			commits: []time.Time{at(5, 9, 0, 0), at(6, 9, 0, 0), at(7, 9, 0, 0)},
+			end:     at(10, 12, 2, 0),
+			want:    []time.Time{at(5, 9, 0, 0), at(6, 9, 0, 0), at(7, 9, 0, 0)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var plan RepositoryPlan
+			for _, commit := range tt.commits {
+				plan.Commits = append(plan.Commits, PlannedCommit{Pattern: CommitPattern{Timestamp: commit}})
+			}
+
+			days, squeezed, err := plan.ShiftBetween(base, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ShiftBetween() error = %v, want error %v", err, tt.wantErr)
+			}
+			if days != tt.days || squeezed != tt.squeezed {
+				t.Errorf("ShiftBetween() = %d, %v, want %d, %v", days, squeezed, tt.days, tt.squeezed)
+			}
+			for i, commit := range plan.Commits {
+				if got := commit.Pattern.Timestamp; !got.Equal(tt.want[i]) {
+					t.Errorf("commit %d at %s, want %s", i, got.Format(time.RFC3339), tt.want[i].Format(time.RFC3339))
+				}
+			}
+		})
+	}
+}
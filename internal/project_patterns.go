@@ -1,6 +1,9 @@
 package internal
 
-import "time"
+import (
+	"math/rand"
+	"time"
+)
 
 type ProjectPhase string
 
@@ -21,23 +24,95 @@ type SprintCycle struct {
 	FocusAreas []string
 }
 
+// sprintPhase is one step of the sprint every schedule repeats
+type sprintPhase struct {
+	Phase     ProjectPhase
+	Days      int
+	Intensity float64
+}
+
+var (
+	sprintPhases = []sprintPhase{
+		{Phase: PhasePlanning, Days: 1, Intensity: 0.3},
+		{Phase: PhaseFeatureDev, Days: 8, Intensity: 0.8},
+		{Phase: PhaseStabilization, Days: 3, Intensity: 0.6},
+		{Phase: PhaseRelease, Days: 2, Intensity: 0.5},
+	}
+
+	hotfixPhase = sprintPhase{Phase: PhaseHotfix, Days: 1, Intensity: 0.9}
+
+	focusAreas = []string{
+		"frontend/ui",
+		"backend/api",
+		"database/schema",
+		"auth/login",
+		"billing/payments",
+		"search/indexing",
+	}
+)
+
 type ProjectPatternGenerator struct {
-	// Implementation will follow
+	hotfixChance float64 // chance a release is followed by a hotfix
 }
 
 func NewProjectPatternGenerator() *ProjectPatternGenerator {
-	return &ProjectPatternGenerator{}
+	return &ProjectPatternGenerator{hotfixChance: 0.3}
 }
 
+// GenerateSprintCycles splits the period into the phases of repeating
+// sprints, sometimes followed by a hotfix. The period starts at a random
+// point of the first sprint so short periods do not always begin with
+// planning.
 func (p *ProjectPatternGenerator) GenerateSprintCycles(startDate, endDate time.Time) []SprintCycle {
-	// Placeholder implementation
-	return []SprintCycle{
-		{
-			StartDate:  startDate,
-			EndDate:    endDate,
-			Phase:      PhaseFeatureDev,
-			Intensity:  0.8,
-			FocusAreas: []string{"frontend/ui", "backend/api"},
-		},
+	sprintDays := 0
+	for _, phase := range sprintPhases {
+		sprintDays += phase.Days
+	}
+
+	var cycles []SprintCycle
+	current := startDate.AddDate(0, 0, -rand.Intn(sprintDays))
+	for current.Before(endDate) {
+		areas := p.pickFocusAreas(2)
+
+		phases := append([]sprintPhase{}, sprintPhases...)
+		if rand.Float64() < p.hotfixChance {
+			phases = append(phases, hotfixPhase)
+		}
+
+		for _, phase := range phases {
+			next := current.AddDate(0, 0, phase.Days)
+			if next.After(startDate) && current.Before(endDate) {
+				cycles = append(cycles, SprintCycle{
+					StartDate:  latest(current, startDate),
+					EndDate:    earliest(next, endDate),
+					Phase:      phase.Phase,
+					Intensity:  phase.Intensity,
+					FocusAreas: areas,
+				})
+			}
+			current = next
+		}
+	}
+
+	return cycles
+}
+
+func (p *ProjectPatternGenerator) pickFocusAreas(n int) []string {
+	areas := append([]string{}, focusAreas...)
+	rand.Shuffle(len(areas), func(i, j int) { areas[i], areas[j] = areas[j], areas[i] })
+	return areas[:n]
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
 	}
+	return b
 }
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// PromptConfig overrides the instructions sent with code changes. Values are
+// Go text/template templates receiving PromptData, keyed by commit type
+// (feature, fix, refactor, docs, test) or change type (e.g.
+// handle_edge_case). A change type template wins over its commit type's.
+type PromptConfig struct {
+	CommitTypes map[string]string `yaml:"commit_types"`
+	ChangeTypes map[string]string `yaml:"change_types"`
+}
+
+// PromptData is what a prompt template can refer to
+type PromptData struct {
+	Pattern   CommitPattern
+	File      string
+	Language  string // e.g. "Go", empty when the extension is not known
+	FocusArea string // the sprint's focus area, e.g. "backend/api"
+}
+
+// defaultPrompts are used for commit types the config does not override
+var defaultPrompts = map[string]string{
+	"feature": `Extend this {{with .Language}}{{.}} {{end}}code with a small, self-contained improvement towards: {{.Pattern.Description}}.
+{{- if .FocusArea}} The team is working on {{.FocusArea}}.{{end}}`,
+	"fix": `Find and fix one plausible bug in this {{with .Language}}{{.}} {{end}}code, such as a missing check, an unhandled error or an off-by-one mistake.
+{{- if .Pattern.Description}} The commit is described as: {{.Pattern.Description}}.{{end}}`,
+	"refactor": `Refactor this {{with .Language}}{{.}} {{end}}code for readability without changing its behaviour, for example by extracting a helper, simplifying a condition or renaming a local variable.`,
+	"docs":     `Improve the documentation in this {{with .Language}}{{.}} {{end}}file: add or clarify comments and doc strings, without changing any code.`,
+	"test":     `Make this {{with .Language}}{{.}} {{end}}code easier to test or add a small check that guards its behaviour, in the style of the file.`,
+}
+
+// genericPrompt is used for commit types without any template
+const genericPrompt = `Review and suggest improvements for this {{with .Language}}{{.}} {{end}}code.`
+
+// PromptTemplates renders the instructions for a code change
+type PromptTemplates struct {
+	commitTypes map[string]*template.Template
+	changeTypes map[string]*template.Template
+	generic     *template.Template
+}
+
+// NewPromptTemplates parses the configured templates over the defaults
+func NewPromptTemplates(cfg PromptConfig) (*PromptTemplates, error) {
+	p := &PromptTemplates{
+		commitTypes: make(map[string]*template.Template),
+		changeTypes: make(map[string]*template.Template),
+	}
+
+	var err error
+	if p.generic, err = template.New("generic").Parse(genericPrompt); err != nil {
+		return nil, err
+	}
+	for name, text := range defaultPrompts {
+		if p.commitTypes[name], err = template.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid default prompt for %s: %w", name, err)
+		}
+	}
+	for name, text := range cfg.CommitTypes {
+		if p.commitTypes[name], err = template.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid prompt template for commit type %s: %w", name, err)
+		}
+	}
+	for name, text := range cfg.ChangeTypes {
+		if p.changeTypes[name], err = template.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("invalid prompt template for change type %s: %w", name, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Instructions renders the template for a pattern's change type, falling
+// back to its commit type and then to generic improvements
+func (p *PromptTemplates) Instructions(pattern CommitPattern, filePath string) (string, error) {
+	tmpl := p.changeTypes[pattern.ChangeType]
+	if tmpl == nil {
+		tmpl = p.commitTypes[pattern.CommitType]
+	}
+	if tmpl == nil {
+		tmpl = p.generic
+	}
+
+	data := PromptData{
+		Pattern:   pattern,
+		File:      filePath,
+		Language:  Language(filePath),
+		FocusArea: pattern.FocusArea,
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %s: %w", tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
+
+// languages names the language of a file extension
+var languages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".jsx": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".java": "Java", ".kt": "Kotlin",
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".hpp": "C++",
+	".cs": "C#", ".rs": "Rust", ".rb": "Ruby", ".php": "PHP", ".swift": "Swift",
+	".scala": "Scala", ".dart": "Dart", ".sh": "shell", ".bash": "shell",
+	".sql": "SQL", ".lua": "Lua", ".md": "Markdown", ".yaml": "YAML", ".yml": "YAML",
+	".json": "JSON", ".toml": "TOML", ".html": "HTML", ".css": "CSS", ".scss": "SCSS",
+	".vue": "Vue", ".svelte": "Svelte",
+}
+
+// Language returns the language of a file from its extension, or an empty
+// string when it is not known
+func Language(filePath string) string {
+	return languages[strings.ToLower(filepath.Ext(filePath))]
+}
@@ -0,0 +1,67 @@
+package internal
+
+import "testing"
+
+func TestInstructions(t *testing.T) {
+	prompts, err := NewPromptTemplates(PromptConfig{
+		CommitTypes: map[string]string{
+			"docs": "Document {{.File}} like this:\n\n    // Name  does  this\n",
+		},
+		ChangeTypes: map[string]string{
+			"handle_edge_case": "Handle an edge case in {{.Language}} file {{.File}} ({{.FocusArea}}).",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pattern CommitPattern
+		file    string
+		want    string
+	}{
+		{
+			name:    "default with a known language",
+			pattern: CommitPattern{CommitType: "refactor"},
+			file:    "main.go",
+			want:    "Refactor this Go code for readability without changing its behaviour, for example by extracting a helper, simplifying a condition or renaming a local variable.",
+		},
+		{
+			name:    "default with an unknown language",
+			pattern: CommitPattern{CommitType: "refactor"},
+			file:    "Makefile",
+			want:    "Refactor this code for readability without changing its behaviour, for example by extracting a helper, simplifying a condition or renaming a local variable.",
+		},
+		{
+			name:    "generic without a language",
+			pattern: CommitPattern{CommitType: "chore"},
+			file:    "Makefile",
+			want:    "Review and suggest improvements for this code.",
+		},
+		{
+			name:    "user template is returned as rendered",
+			pattern: CommitPattern{CommitType: "docs"},
+			file:    "main.go",
+			want:    "Document main.go like this:\n\n    // Name  does  this\n",
+		},
+		{
+			name:    "change type wins over commit type",
+			pattern: CommitPattern{CommitType: "fix", ChangeType: "handle_edge_case", FocusArea: "backend/api"},
+			file:    "api.py",
+			want:    "Handle an edge case in Python file api.py (backend/api).",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := prompts.Instructions(tt.pattern, tt.file)
+			if err != nil {
+				t.Fatalf("Instructions() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Instructions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
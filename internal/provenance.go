@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultNotesRef is where provenance notes are stored unless configured
+// otherwise. Read them with `git log --notes=devmetrics`.
+const DefaultNotesRef = "refs/notes/devmetrics"
+
+// Provenance identifies the run and pattern that produced a synthetic commit
+type Provenance struct {
+	RunID   string
+	Pattern CommitPattern
+}
+
+// Trailers returns the git trailers marking a commit as synthetic
+func (p Provenance) Trailers() []string {
+	trailers := []string{
+		"Generated-By: devmetrics",
+		"Devmetrics-Run: " + p.RunID,
+	}
+
+	add := func(key, value string) {
+		if value != "" {
+			trailers = append(trailers, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	add("Devmetrics-Persona", p.Pattern.Persona)
+	add("Devmetrics-Commit-Type", p.Pattern.CommitType)
+	add("Devmetrics-Change-Type", p.Pattern.ChangeType)
+	add("Devmetrics-Sprint-Phase", string(p.Pattern.SprintPhase))
+
+	return trailers
+}
+
+// AppendTrailers adds the provenance trailers to a commit message
+func (p Provenance) AppendTrailers(message string) string {
+	return strings.TrimRight(message, " \t\n") + "\n\n" + strings.Join(p.Trailers(), "\n") + "\n"
+}
+
+// HasRunTrailer reports whether a commit or tag message carries the trailer
+// of the given run
+func HasRunTrailer(message, runID string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		if line == "Devmetrics-Run: "+runID {
+			return true
+		}
+	}
+	return false
+}
+
+// CommitNote is the full provenance record attached to a commit as a git note
+type CommitNote struct {
+	GeneratedBy  string        `json:"generated_by"`
+	RunID        string        `json:"run_id"`
+	Pattern      CommitPattern `json:"pattern"`
+	Files        []string      `json:"files"`
+	Changes      []string      `json:"changes"`
+	CommitPrompt string        `json:"commit_prompt"`
+	LLM          NoteLLM       `json:"llm"`
+}
+
+// NoteLLM records the LLM settings used to generate a commit
+type NoteLLM struct {
+	Provider    string  `json:"provider"`
+	Model       string  `json:"model"`
+	Temperature float64 `json:"temperature"`
+	MaxTokens   int     `json:"max_tokens"`
+	Seed        int64   `json:"seed,omitempty"`
+}
+
+// NewNoteLLM captures the note-relevant fields of an LLM config
+func NewNoteLLM(cfg LLMConfig) NoteLLM {
+	return NoteLLM{
+		Provider:    cfg.Provider,
+		Model:       cfg.Model,
+		Temperature: cfg.Temperature,
+		MaxTokens:   cfg.MaxTokens,
+		Seed:        cfg.Seed,
+	}
+}
+
+// AddNote attaches a JSON note to commit under notesRef, replacing any
+// existing note for that commit
+func (g *GitOperations) AddNote(notesRef string, commit plumbing.Hash, note CommitNote) error {
+	note.GeneratedBy = "devmetrics"
+	data, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal note: %w", err)
+	}
+
+	blob, err := g.storeBlob(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+
+	refName := plumbing.ReferenceName(notesRef)
+	entries, parent, err := g.readNotes(refName)
+	if err != nil {
+		return err
+	}
+
+	var kept []object.TreeEntry
+	for _, entry := range entries {
+		if entry.Name != commit.String() {
+			kept = append(kept, entry)
+		}
+	}
+	kept = append(kept, object.TreeEntry{
+		Name: commit.String(),
+		Mode: filemode.Regular,
+		Hash: blob,
+	})
+
+	return g.writeNotes(refName, kept, parent, "Notes added by devmetrics\n")
+}
+
+// RemoveNotes drops the notes of commits from notesRef and returns how many
+// there were. The ref is deleted once it holds no notes.
+func (g *GitOperations) RemoveNotes(notesRef string, commits []plumbing.Hash) (int, error) {
+	refName := plumbing.ReferenceName(notesRef)
+	entries, parent, err := g.readNotes(refName)
+	if err != nil || parent.IsZero() {
+		return 0, err
+	}
+
+	remove := make(map[string]bool)
+	for _, commit := range commits {
+		remove[commit.String()] = true
+	}
+	var kept []object.TreeEntry
+	for _, entry := range entries {
+		if !remove[entry.Name] {
+			kept = append(kept, entry)
+		}
+	}
+
+	removed := len(entries) - len(kept)
+	switch {
+	case removed == 0:
+		return 0, nil
+	case len(kept) == 0:
+		if err := g.repo.Storer.RemoveReference(refName); err != nil {
+			return 0, fmt.Errorf("failed to delete notes ref: %w", err)
+		}
+		return removed, nil
+	}
+	return removed, g.writeNotes(refName, kept, parent, "Notes removed by devmetrics\n")
+}
+
+// readNotes returns the entries of the notes tree at refName and the notes
+// commit holding them, or nothing if the ref does not exist yet
+func (g *GitOperations) readNotes(refName plumbing.ReferenceName) ([]object.TreeEntry, plumbing.Hash, error) {
+	ref, err := g.repo.Reference(refName, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to read notes ref: %w", err)
+	}
+
+	notes, err := g.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to read notes commit: %w", err)
+	}
+	tree, err := notes.Tree()
+	if err != nil {
+		return nil, plumbing.ZeroHash, fmt.Errorf("failed to read notes tree: %w", err)
+	}
+	return tree.Entries, notes.Hash, nil
+}
+
+// writeNotes commits entries as the new notes tree on top of parent, which
+// is zero for the first notes commit, and points refName at it
+func (g *GitOperations) writeNotes(refName plumbing.ReferenceName, entries []object.TreeEntry, parent plumbing.Hash, message string) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	treeHash, err := g.storeObject(&object.Tree{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	var parents []plumbing.Hash
+	if !parent.IsZero() {
+		parents = append(parents, parent)
+	}
+	sig := devMetricsSignature(time.Now())
+	notesCommit, err := g.storeObject(&object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(refName, notesCommit)); err != nil {
+		return fmt.Errorf("failed to update notes ref: %w", err)
+	}
+
+	return nil
+}
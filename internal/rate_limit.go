@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces out LLM requests so they stay within a number of
+// requests and tokens per minute. Either limit may be zero for no limit.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+// tokenBucket holds up to a minute's worth of capacity and refills it
+// continuously
+type tokenBucket struct {
+	capacity  float64
+	available float64
+	last      time.Time
+}
+
+// NewRateLimiter creates a limiter, or returns nil if neither limit is set
+func NewRateLimiter(requestsPerMinute, tokensPerMinute int) *RateLimiter {
+	if requestsPerMinute <= 0 && tokensPerMinute <= 0 {
+		return nil
+	}
+
+	r := &RateLimiter{}
+	now := time.Now()
+	if requestsPerMinute > 0 {
+		r.requests = &tokenBucket{capacity: float64(requestsPerMinute), available: float64(requestsPerMinute), last: now}
+	}
+	if tokensPerMinute > 0 {
+		r.tokens = &tokenBucket{capacity: float64(tokensPerMinute), available: float64(tokensPerMinute), last: now}
+	}
+	return r
+}
+
+// Wait blocks until a request of the given number of tokens may be sent or
+// ctx is cancelled. A nil limiter never waits.
+func (r *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	if r == nil {
+		return ctx.Err()
+	}
+
+	for {
+		delay := r.take(float64(tokens))
+		if delay == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// take uses up one request and the tokens if both are available, or returns
+// how long to wait before they may be
+func (r *RateLimiter) take(tokens float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.requests.refill(now)
+	r.tokens.refill(now)
+
+	delay := r.requests.wait(1)
+	if d := r.tokens.wait(tokens); d > delay {
+		delay = d
+	}
+	if delay > 0 {
+		return delay
+	}
+
+	r.requests.use(1)
+	r.tokens.use(tokens)
+	return 0
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.available += now.Sub(b.last).Minutes() * b.capacity
+	if b.available > b.capacity {
+		b.available = b.capacity
+	}
+	b.last = now
+}
+
+// wait returns how long until n is available. Requests larger than the whole
+// bucket only wait for it to be full.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if n > b.capacity {
+		n = b.capacity
+	}
+	if b.available >= n {
+		return 0
+	}
+	if d := time.Duration((n - b.available) / b.capacity * float64(time.Minute)); d > time.Millisecond {
+		return d
+	}
+	return time.Millisecond
+}
+
+func (b *tokenBucket) use(n float64) {
+	if b != nil {
+		b.available -= n
+	}
+}
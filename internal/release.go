@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Defaults for release tags and branches
+const (
+	DefaultTagPrefix           = "v"
+	DefaultReleaseBranchPrefix = "release/"
+)
+
+// Version parts a release bumps
+const (
+	ReleaseMinor = "minor"
+	ReleasePatch = "patch"
+)
+
+var semverPattern = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+// SetReleases enables release patterns at the end of release and hotfix phases
+func (g *CommitPatternGenerator) SetReleases(enabled bool) {
+	g.releases = enabled
+}
+
+// addRelease ends a release phase with a minor release. Hotfix phases put
+// their commits on the release branch and end with a patch release. The
+// release is never dated after end and is left out when there is no room for
+// it.
+func (g *CommitPatternGenerator) addRelease(patterns []CommitPattern, cycle SprintCycle, end time.Time) []CommitPattern {
+	level := ""
+	switch cycle.Phase {
+	case PhaseRelease:
+		level = ReleaseMinor
+	case PhaseHotfix:
+		level = ReleasePatch
+	}
+	if level == "" || len(patterns) == 0 {
+		return patterns
+	}
+
+	SortPatterns(patterns)
+	if level == ReleasePatch {
+		for i := range patterns {
+			patterns[i].Hotfix = true
+		}
+	}
+
+	last := patterns[len(patterns)-1]
+	releaseTime, ok := followUpTime(last.Timestamp, end, time.Duration(30+rand.Intn(90))*time.Minute)
+	if !ok {
+		return patterns
+	}
+	return append(patterns, CommitPattern{
+		Timestamp:   releaseTime,
+		CommitType:  "release",
+		ChangeType:  "version_bump",
+		Description: "Release",
+		Persona:     last.Persona,
+		SprintPhase: cycle.Phase,
+		Hotfix:      level == ReleasePatch,
+		Release:     level,
+	})
+}
+
+// Version is a semantic version without pre-release or build metadata
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a tag name such as v1.2.3 with the given prefix
+func ParseVersion(tag, prefix string) (Version, bool) {
+	parts := strings.Split(strings.TrimPrefix(tag, prefix), ".")
+	if !strings.HasPrefix(tag, prefix) || len(parts) != 3 {
+		return Version{}, false
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, false
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, true
+}
+
+// Bump returns the next version for a release of the given level
+func (v Version) Bump(level string) Version {
+	if level == ReleasePatch {
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+	return Version{Major: v.Major, Minor: v.Minor + 1}
+}
+
+// Less reports whether v sorts before other
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	return v.Patch < other.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// BumpVersionFile replaces the first x.y.z in content with version, or
+// returns just the version when there is none
+func BumpVersionFile(content string, version Version) string {
+	if loc := semverPattern.FindStringIndex(content); loc != nil {
+		return content[:loc[0]] + version.String() + content[loc[1]:]
+	}
+	return version.String() + "\n"
+}
+
+// AddChangelogEntry puts a section for version above the existing entries,
+// keeping a leading "# " title at the top
+func AddChangelogEntry(content string, version Version, date time.Time, changes []string) string {
+	var entry strings.Builder
+	fmt.Fprintf(&entry, "## %s - %s\n\n", version, date.Format("2006-01-02"))
+	for _, change := range changes {
+		fmt.Fprintf(&entry, "- %s\n", change)
+	}
+	if len(changes) == 0 {
+		entry.WriteString("- Maintenance release\n")
+	}
+	entry.WriteString("\n")
+
+	if content == "" {
+		return "# Changelog\n\n" + entry.String()
+	}
+	if strings.HasPrefix(content, "# ") {
+		title, rest, _ := strings.Cut(content, "\n")
+		return title + "\n\n" + entry.String() + strings.TrimLeft(rest, "\n")
+	}
+	return entry.String() + content
+}
+
+// LatestVersion returns the highest version tagged with prefix and the commit
+// it tags. The zero version and hash are returned when there is none.
+func (g *GitOperations) LatestVersion(prefix string) (Version, plumbing.Hash, error) {
+	tags, err := g.repo.Tags()
+	if err != nil {
+		return Version{}, plumbing.ZeroHash, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	var latest Version
+	var commit plumbing.Hash
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		version, ok := ParseVersion(ref.Name().Short(), prefix)
+		if !ok || (!commit.IsZero() && !latest.Less(version)) {
+			return nil
+		}
+
+		hash := ref.Hash()
+		if tag, err := g.repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		latest, commit = version, hash
+		return nil
+	})
+	if err != nil {
+		return Version{}, plumbing.ZeroHash, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	return latest, commit, nil
+}
+
+// CreateTag creates an annotated tag on commit dated timestamp
+func (g *GitOperations) CreateTag(name string, commit plumbing.Hash, message string, timestamp time.Time) error {
+	tagger := devMetricsSignature(timestamp)
+	_, err := g.repo.CreateTag(name, commit, &git.CreateTagOptions{
+		Tagger:  &tagger,
+		Message: message,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tag %s: %w", name, err)
+	}
+	return nil
+}
+
+// HasTag reports whether a tag exists
+func (g *GitOperations) HasTag(name string) bool {
+	_, err := g.repo.Tag(name)
+	return err == nil
+}
+
+// DeleteTag removes a tag
+func (g *GitOperations) DeleteTag(name string) error {
+	if err := g.repo.DeleteTag(name); err != nil {
+		return fmt.Errorf("failed to delete tag %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddReleaseTime(t *testing.T) {
+	last := time.Date(2024, 3, 8, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		phase       ProjectPhase
+		end         time.Time
+		earliest    time.Time // first time the release may have
+		latest      time.Time // last time the release may have
+		wantRelease string    // version part bumped, empty for no release
+	}{
+		{"release phase", PhaseRelease, last.Add(24 * time.Hour), last.Add(30 * time.Minute), last.Add(120 * time.Minute), ReleaseMinor},
+		{"hotfix squeezed before the end", PhaseHotfix, last.Add(5 * time.Minute), last.Add(time.Second), last.Add(5 * time.Minute), ReleasePatch},
+		{"no room left", PhaseRelease, last, time.Time{}, time.Time{}, ""},
+	}
+
+	g := NewCommitPatternGenerator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []CommitPattern{
+				{Timestamp: last.Add(-time.Hour), CommitType: "bugfix"},
+				{Timestamp: last, CommitType: "bugfix"},
+			}
+			patterns = g.addRelease(patterns, SprintCycle{Phase: tt.phase}, tt.end)
+
+			release := patterns[len(patterns)-1]
+			if tt.wantRelease == "" {
+				if len(patterns) != 2 {
+					t.Errorf("got release at %s, want none", release.Timestamp)
+				}
+				return
+			}
+			if len(patterns) != 3 || release.Release != tt.wantRelease {
+				t.Fatalf("got %+v, want a %s release", release, tt.wantRelease)
+			}
+			if when := release.Timestamp; when.Before(tt.earliest) || when.After(tt.latest) {
+				t.Errorf("release at %s, want between %s and %s", when, tt.earliest, tt.latest)
+			}
+		})
+	}
+}
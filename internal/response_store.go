@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ResponseStore is a content-addressed cache of LLM responses shared by every
+// run. Each response lives in its own file named after the request key, so
+// rerunning a scenario replays earlier answers instead of calling the LLM.
+type ResponseStore struct {
+	dir string
+}
+
+// StoredResponse is a single cached LLM response
+type StoredResponse struct {
+	Key       string    `json:"key"`
+	Model     string    `json:"model"`
+	Task      Task      `json:"task"`
+	File      string    `json:"file,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Response  string    `json:"response"`
+}
+
+// CacheStats summarizes the contents of a response store
+type CacheStats struct {
+	Entries int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+	ByModel map[string]int
+	ByTask  map[Task]int
+}
+
+// OpenResponseStore returns the store in dir, which is created on first write
+func OpenResponseStore(dir string) *ResponseStore {
+	return &ResponseStore{dir: dir}
+}
+
+func (s *ResponseStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key+".json")
+}
+
+// Get returns the cached response for key
+func (s *ResponseStore) Get(key string) (string, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return "", false
+	}
+
+	var entry StoredResponse
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		return "", false
+	}
+	return entry.Response, true
+}
+
+// Put stores a response under its key
+func (s *ResponseStore) Put(entry StoredResponse) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached response: %w", err)
+	}
+
+	path := s.path(entry.Key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write through a temp file so concurrent runs never see half an entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	return nil
+}
+
+// Stats counts the cached responses
+func (s *ResponseStore) Stats() (CacheStats, error) {
+	stats := CacheStats{ByModel: make(map[string]int), ByTask: make(map[Task]int)}
+	err := s.walk(func(path string, entry StoredResponse, size int64) error {
+		stats.Entries++
+		stats.Bytes += size
+		stats.ByModel[entry.Model]++
+		stats.ByTask[entry.Task]++
+		if stats.Oldest.IsZero() || entry.CreatedAt.Before(stats.Oldest) {
+			stats.Oldest = entry.CreatedAt
+		}
+		if entry.CreatedAt.After(stats.Newest) {
+			stats.Newest = entry.CreatedAt
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Clear removes cached responses older than olderThan, or every response if
+// olderThan is zero, and returns how many were removed
+func (s *ResponseStore) Clear(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	err := s.walk(func(path string, entry StoredResponse, size int64) error {
+		if olderThan > 0 && entry.CreatedAt.After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove cached response: %w", err)
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	// Drop the fan-out directories that are now empty
+	dirs, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return removed, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	for _, dir := range dirs {
+		if dir.IsDir() {
+			os.Remove(filepath.Join(s.dir, dir.Name()))
+		}
+	}
+	return removed, nil
+}
+
+// walk calls fn for every readable entry in the store
+func (s *ResponseStore) walk(fn func(path string, entry StoredResponse, size int64) error) error {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read cache directory: %w", err)
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cached response: %w", err)
+		}
+		var entry StoredResponse
+		if err := json.Unmarshal(data, &entry); err != nil {
+			// Not something the store wrote
+			return nil
+		}
+		return fn(path, entry, int64(len(data)))
+	})
+}
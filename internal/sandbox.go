@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+const (
+	SandboxOutputDirectory = "directory"
+	SandboxOutputBundle    = "bundle"
+)
+
+func init() {
+	// Serve local clones in-process so sandbox mode does not depend on a git
+	// binary being installed
+	client.InstallProtocol("file", server.NewClient(localLoader{}))
+}
+
+// CloneSandbox clones the repository at repoPath into a fresh scratch
+// directory under baseDir and returns the clone's path
+func CloneSandbox(repoPath, baseDir string) (string, error) {
+	source, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+
+	if baseDir == "" {
+		baseDir = os.TempDir()
+	}
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+
+	dir, err := os.MkdirTemp(baseDir, filepath.Base(source)+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+
+	if _, err := git.PlainClone(dir, false, &git.CloneOptions{URL: source}); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to clone %s into sandbox: %w", repoPath, err)
+	}
+
+	return dir, nil
+}
+
+// WriteBundle writes every branch and tag of the repository at repoPath to a
+// git bundle that can be fetched or cloned with the git CLI
+func WriteBundle(repoPath, bundlePath string) error {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return fmt.Errorf("invalid git repository: %w", err)
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+
+	var bundleRefs []*plumbing.Reference
+	var tips []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			return nil
+		}
+		bundleRefs = append(bundleRefs, ref)
+		tips = append(tips, ref.Hash())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to read repository head: %w", err)
+	}
+
+	objects, err := revlist.Objects(repo.Storer, tips, nil)
+	if err != nil {
+		return fmt.Errorf("failed to collect objects: %w", err)
+	}
+
+	f, err := os.Create(bundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# v2 git bundle")
+	fmt.Fprintf(w, "%s HEAD\n", head.Hash())
+	for _, ref := range bundleRefs {
+		fmt.Fprintf(w, "%s %s\n", ref.Hash(), ref.Name())
+	}
+	fmt.Fprintln(w)
+
+	encoder := packfile.NewEncoder(w, repo.Storer, false)
+	if _, err := encoder.Encode(objects, 10); err != nil {
+		return fmt.Errorf("failed to write bundle packfile: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write bundle file: %w", err)
+	}
+
+	return nil
+}
+
+// BundlePath returns where the bundle for a sandboxed clone is written
+func BundlePath(sandboxDir string) string {
+	return fmt.Sprintf("%s-%s.bundle", sandboxDir, time.Now().Format("20060102-150405"))
+}
+
+// localLoader resolves file endpoints to either a bare repository or the
+// .git directory of a working copy
+type localLoader struct{}
+
+func (localLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	for _, dir := range []string{filepath.Join(ep.Path, git.GitDirName), ep.Path} {
+		if _, err := os.Stat(filepath.Join(dir, "config")); err == nil {
+			return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault()), nil
+		}
+	}
+	return nil, transport.ErrRepositoryNotFound
+}
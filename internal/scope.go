@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// DefaultPromptTokens is how large a file may be before only part of it is
+// sent with a code change, unless configured otherwise
+const DefaultPromptTokens = 1500
+
+// scopeContextLines is how many lines around the chosen function are sent
+// along with it
+const scopeContextLines = 8
+
+// editScope is the part of a file a code change is asked for. Lines keep
+// their line endings, and Start and End index them, End exclusive.
+type editScope struct {
+	lines      []string
+	Start, End int
+	Function   string // name of the function the scope is built around, if any
+}
+
+// Whole reports whether the scope covers the entire file
+func (s editScope) Whole() bool {
+	return s.Start == 0 && s.End == len(s.lines)
+}
+
+// Excerpt returns the content inside the scope
+func (s editScope) Excerpt() string {
+	return strings.Join(s.lines[s.Start:s.End], "")
+}
+
+// Splice replaces the content inside the scope with excerpt and returns the
+// whole file
+func (s editScope) Splice(excerpt string) string {
+	return strings.Join(s.lines[:s.Start], "") + excerpt + strings.Join(s.lines[s.End:], "")
+}
+
+// Describe tells the LLM which part of the file it is looking at
+func (s editScope) Describe() string {
+	if s.Whole() {
+		return ""
+	}
+	where := fmt.Sprintf("lines %d to %d", s.Start+1, s.End)
+	if s.Function != "" {
+		where += fmt.Sprintf(", around %s", s.Function)
+	}
+	return fmt.Sprintf(" (excerpt of %s; the rest of the file is unchanged)", where)
+}
+
+// chooseScope picks the part of a file to edit so the prompt stays within
+// maxTokens, roughly four characters to a token. Small files are edited
+// whole. Larger ones are edited one function at a time, with a few lines of
+// context, or one window of lines if no function fits. The choice only
+// depends on the pattern and file, so a file is asked about the same way
+// every time.
+func chooseScope(modifier *FileModifier, pattern CommitPattern, filePath, content string, maxTokens int) editScope {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	whole := editScope{lines: lines, Start: 0, End: len(lines)}
+
+	maxChars := maxTokens * 4
+	if maxTokens <= 0 || len(content) <= maxChars || len(lines) == 0 {
+		return whole
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(pattern.Timestamp.String() + "\x00" + filePath))
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	size := func(start, end int) int {
+		n := 0
+		for _, line := range lines[start:end] {
+			n += len(line)
+		}
+		return n
+	}
+
+	var fitting []editScope
+	if metadata, err := modifier.PrepareFileContent(filePath, content); err == nil {
+		for _, fn := range metadata.Functions {
+			start := fn.StartLine - scopeContextLines
+			if start < 0 {
+				start = 0
+			}
+			end := min(len(lines), fn.EndLine+scopeContextLines)
+			if start < end && size(start, end) <= maxChars {
+				fitting = append(fitting, editScope{lines: lines, Start: start, End: end, Function: fn.Name})
+			}
+		}
+	}
+	if len(fitting) > 0 {
+		return fitting[rng.Intn(len(fitting))]
+	}
+
+	// Without a function that fits, take as many lines as fit around a
+	// random line
+	start := rng.Intn(len(lines))
+	end := start
+	for end < len(lines) && size(start, end+1) <= maxChars {
+		end++
+	}
+	for start > 0 && size(start-1, end) <= maxChars {
+		start--
+	}
+	if end == start {
+		// A single line is longer than the budget
+		end = start + 1
+	}
+	return editScope{lines: lines, Start: start, End: end}
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedJSON is returned for structured output that is not the JSON
+// object asked for
+var ErrMalformedJSON = errors.New("malformed JSON")
+
+// maxSubjectLength is the longest commit subject line accepted from an LLM
+const maxSubjectLength = 72
+
+// StructuredChange is the single response asked for in structured output
+// mode: the edit to a file, what it does and a commit message for it
+type StructuredChange struct {
+	Patch         string `json:"patch"`          // unified diff against the file
+	Summary       string `json:"summary"`        // one sentence describing the patch
+	CommitMessage string `json:"commit_message"` // conventional commit message
+}
+
+// structuredChangeSchema is the JSON schema StructuredChange responses must
+// follow
+var structuredChangeSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"patch": map[string]any{
+			"type":        "string",
+			"description": "Unified diff against the file, starting every hunk with an @@ header",
+		},
+		"summary": map[string]any{
+			"type":        "string",
+			"description": "One brief sentence describing what the patch changes",
+		},
+		"commit_message": map[string]any{
+			"type":        "string",
+			"description": "Conventional commit message, first line at most 72 characters",
+		},
+	},
+	"required":             []string{"patch", "summary", "commit_message"},
+	"additionalProperties": false,
+}
+
+// ParseStructuredChange reads a structured change from an LLM response,
+// tolerating code fences and text around the JSON object, and checks it has
+// every field the schema requires
+func ParseStructuredChange(response string) (StructuredChange, error) {
+	var change StructuredChange
+
+	start := strings.Index(response, "{")
+	end := strings.LastIndex(response, "}")
+	if start < 0 || end < start {
+		if start >= 0 {
+			return change, fmt.Errorf("%w: %w: object is never closed", ErrMalformedJSON, ErrTruncated)
+		}
+		return change, fmt.Errorf("%w: response contains no JSON object", ErrMalformedJSON)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(response[start : end+1])))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&change); err != nil {
+		return change, fmt.Errorf("%w: %v", ErrMalformedJSON, err)
+	}
+
+	var missing []string
+	for _, field := range []struct{ name, value string }{
+		{"patch", change.Patch},
+		{"summary", change.Summary},
+		{"commit_message", change.CommitMessage},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) > 0 {
+		return change, fmt.Errorf("%w: missing %s", ErrMalformedJSON, strings.Join(missing, ", "))
+	}
+
+	change.Summary = strings.TrimSpace(change.Summary)
+	change.CommitMessage = strings.TrimSpace(change.CommitMessage)
+	if subject := Subject(change.CommitMessage); len(subject) > maxSubjectLength {
+		return change, fmt.Errorf("commit message subject is %d characters, more than %d", len(subject), maxSubjectLength)
+	}
+	return change, nil
+}
+
+// Subject returns the first line of a commit message
+func Subject(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseStructuredChange(t *testing.T) {
+	const object = `{"patch": "@@ -1 +1 @@\n-a\n+b\n", "summary": " Rename a to b. ", "commit_message": "refactor: rename a to b\n\nKeeps names short."}`
+	want := StructuredChange{
+		Patch:         "@@ -1 +1 @@\n-a\n+b\n",
+		Summary:       "Rename a to b.",
+		CommitMessage: "refactor: rename a to b\n\nKeeps names short.",
+	}
+	tests := []struct {
+		name     string
+		response string
+		err      error // expected error, nil for success
+		anyErr   bool  // any error is expected
+	}{
+		{name: "bare object", response: object},
+		{name: "fenced object", response: "```json\n" + object + "\n```\n"},
+		{name: "text around the object", response: "Here is the change:\n" + object + "\nLet me know."},
+		{name: "no object", response: "I could not change this file.", err: ErrMalformedJSON},
+		{name: "object never closed", response: `{"patch": "@@ -1 +1 @@`, err: ErrTruncated},
+		{name: "invalid JSON", response: `{"patch": "a", "summary": b}`, err: ErrMalformedJSON},
+		{name: "unknown field", response: strings.Replace(object, `"summary"`, `"notes": "x", "summary"`, 1), err: ErrMalformedJSON},
+		{name: "missing field", response: `{"patch": "@@ -1 +1 @@\n-a\n+b\n", "summary": "s"}`, err: ErrMalformedJSON},
+		{name: "blank field", response: strings.Replace(object, `" Rename a to b. "`, `"  "`, 1), err: ErrMalformedJSON},
+		{name: "subject too long", response: strings.Replace(object, "rename a to b", strings.Repeat("x", 80), 1), anyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStructuredChange(tt.response)
+			switch {
+			case tt.err != nil || tt.anyErr:
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("ParseStructuredChange() error = %v, want %v", err, tt.err)
+				}
+			case err != nil:
+				t.Errorf("ParseStructuredChange() error = %v", err)
+			case got != want:
+				t.Errorf("ParseStructuredChange() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+// fileSnapshot is what a file looked like before the commit being built
+// changed it
+type fileSnapshot struct {
+	existed bool
+	content []byte
+	mode    fs.FileMode
+	entry   *index.Entry // index entry, nil if the file was not staged
+}
+
+// snapshot records the original state of a file the first time the commit
+// being built changes it, so Rollback can put it back
+func (g *GitOperations) snapshot(filePath string) error {
+	if g.objects != nil {
+		// Changes only exist in memory until they are committed
+		return nil
+	}
+
+	name := filepath.ToSlash(filePath)
+	if _, ok := g.snapshots[name]; ok {
+		return nil
+	}
+
+	var snap fileSnapshot
+	fullPath := filepath.Join(g.repoPath, filePath)
+	info, err := os.Lstat(fullPath)
+	switch {
+	case err == nil:
+		snap.content, err = os.ReadFile(fullPath)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot %s: %w", filePath, err)
+		}
+		snap.existed = true
+		snap.mode = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to snapshot %s: %w", filePath, err)
+	}
+
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if entry, err := idx.Entry(name); err == nil {
+		copied := *entry
+		snap.entry = &copied
+	}
+
+	if g.snapshots == nil {
+		g.snapshots = make(map[string]fileSnapshot)
+	}
+	g.snapshots[name] = snap
+	return nil
+}
+
+// Rollback discards every change made since the last commit: files are
+// restored to their original content, files that did not exist are removed
+// and the index is put back the way it was
+func (g *GitOperations) Rollback() error {
+	if g.objects != nil {
+		g.objects.pending = make(map[string]string)
+		g.objects.removed = make(map[string]bool)
+		return nil
+	}
+	if len(g.snapshots) == 0 {
+		return nil
+	}
+
+	for name, snap := range g.snapshots {
+		fullPath := filepath.Join(g.repoPath, filepath.FromSlash(name))
+		if !snap.existed {
+			if err := os.Remove(fullPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+			g.removeEmptyDirs(filepath.Dir(fullPath))
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+		if err := os.WriteFile(fullPath, snap.content, snap.mode); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		if err := os.Chmod(fullPath, snap.mode); err != nil {
+			return fmt.Errorf("failed to restore mode of %s: %w", name, err)
+		}
+	}
+
+	// Files may already have been staged when the commit itself failed
+	idx, err := g.repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	entries := idx.Entries[:0]
+	for _, entry := range idx.Entries {
+		if _, ok := g.snapshots[entry.Name]; !ok {
+			entries = append(entries, entry)
+		}
+	}
+	idx.Entries = entries
+	for _, snap := range g.snapshots {
+		if snap.entry != nil {
+			idx.Entries = append(idx.Entries, snap.entry)
+		}
+	}
+	idx.Cache = nil // the tree cache may describe the discarded changes
+	if err := g.repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("failed to restore index: %w", err)
+	}
+
+	g.snapshots = nil
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to the repository root for
+// as long as they are empty
+func (g *GitOperations) removeEmptyDirs(dir string) {
+	for {
+		rel, err := filepath.Rel(g.repoPath, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+		// Removing a directory that still has entries fails
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Outcomes of a validation check, from best to worst
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+var checkSeverity = map[string]int{CheckPass: 0, CheckWarn: 1, CheckFail: 2}
+
+// Check is the outcome of a single validation check
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RepositoryReport holds the validation checks of one configured repository
+type RepositoryReport struct {
+	Path    string        `json:"path"`
+	Status  string        `json:"status"`
+	Checks  []Check       `json:"checks"`
+	Skipped []SkippedFile `json:"skipped,omitempty"`
+}
+
+func (r *RepositoryReport) add(name, status, detail string) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: detail})
+	if checkSeverity[status] > checkSeverity[r.Status] {
+		r.Status = status
+	}
+}
+
+// ValidateRepository checks that a repository can be generated into without
+// writing anything to it
+func ValidateRepository(repo Repository) RepositoryReport {
+	path := filepath.Clean(repo.Path)
+	report := RepositoryReport{Path: path, Status: CheckPass}
+
+	if _, err := os.Stat(path); err != nil {
+		report.add("path", CheckFail, "does not exist")
+		return report
+	}
+	report.add("path", CheckPass, "")
+
+	gitOps, err := OpenRepository(path)
+	if err != nil {
+		report.add("repository", CheckFail, err.Error())
+		return report
+	}
+	if gitOps.IsBare() {
+		if repo.Backend != BackendObjects {
+			report.add("repository", CheckFail, fmt.Sprintf("bare repositories require the %s backend", BackendObjects))
+			return report
+		}
+		report.add("repository", CheckPass, "bare")
+	} else {
+		report.add("repository", CheckPass, "")
+	}
+
+	branch, head, err := gitOps.Head()
+	switch {
+	case err != nil:
+		report.add("head", CheckFail, err.Error())
+		return report
+	case branch == "":
+		report.add("head", CheckWarn, fmt.Sprintf("detached at %s, topic and release branches are disabled", head.String()[:7]))
+	default:
+		report.add("head", CheckPass, fmt.Sprintf("%s at %s", branch, head.String()[:7]))
+	}
+
+	if clean, err := gitOps.IsClean(); err != nil {
+		report.add("clean", CheckFail, err.Error())
+	} else if !clean {
+		report.add("clean", CheckFail, "uncommitted changes")
+	} else {
+		report.add("clean", CheckPass, "")
+	}
+
+	if err := gitOps.CheckWritable(); err != nil {
+		report.add("writable", CheckFail, err.Error())
+	} else {
+		report.add("writable", CheckPass, "")
+	}
+
+	// Match files the way the configured backend will
+	if repo.Backend == BackendObjects {
+		if err := gitOps.UseObjectBackend(); err != nil {
+			report.add("files", CheckFail, err.Error())
+			return report
+		}
+	}
+
+	files, skipped, err := gitOps.ScanFiles(repo)
+	if err != nil {
+		report.add("files", CheckFail, err.Error())
+		return report
+	}
+	report.Skipped = skipped
+
+	for _, pattern := range repo.Patterns {
+		matched := 0
+		for _, file := range files {
+			if matchesAny([]string{pattern}, filepath.ToSlash(file)) {
+				matched++
+			}
+		}
+		if matched == 0 {
+			report.add("pattern "+pattern, CheckWarn, "matches no eligible files")
+		} else {
+			report.add("pattern "+pattern, CheckPass, fmt.Sprintf("%d files", matched))
+		}
+	}
+
+	detail := fmt.Sprintf("%d eligible, %d skipped", len(files), len(skipped))
+	if len(files) == 0 {
+		report.add("files", CheckFail, detail)
+	} else {
+		report.add("files", CheckPass, detail)
+	}
+
+	return report
+}
+
+// ValidateLLM checks that the configured LLM answers a tiny prompt
+func ValidateLLM(ctx context.Context, cfg LLMConfig) Check {
+	check := Check{Name: "llm", Status: CheckFail}
+
+	llm, err := ConnectLLM(cfg)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	defer llm.Close()
+
+	start := time.Now()
+	if err := llm.Ping(ctx); err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.Status = CheckPass
+	if cfg.Provider == ProviderOffline {
+		check.Detail = fmt.Sprintf("offline provider with seed %d", cfg.Seed)
+		return check
+	}
+	check.Detail = fmt.Sprintf("%s %s answered in %s", cfg.Provider, cfg.Model, time.Since(start).Round(time.Millisecond))
+	return check
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultBranchPrefix is prepended to generated topic branch names
+const DefaultBranchPrefix = "feature/"
+
+// Change types of the commits that bring a topic branch back
+const (
+	ChangeMerge       = "merge"
+	ChangeSquashMerge = "squash_merge"
+)
+
+const (
+	maxTopicCommits = 5
+	maxTopicSpan    = 3 * 24 * time.Hour
+)
+
+var nonSlugChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// SetWorkflow configures how generated commits are spread over branches
+func (g *CommitPatternGenerator) SetWorkflow(workflow WorkflowConfig) {
+	if workflow.BranchPrefix == "" {
+		workflow.BranchPrefix = DefaultBranchPrefix
+	}
+	g.workflow = workflow
+}
+
+// assignTopicBranches moves the feature commits of a sprint cycle onto
+// short-lived topic branches of 1-5 commits spanning at most three days, and
+// adds the commit merging each branch back to the main branch a little after
+// its last commit. Merges are never dated after end; a branch whose last
+// commit leaves no room before end stays unmerged.
+func (g *CommitPatternGenerator) assignTopicBranches(patterns []CommitPattern, topics *topicNamer, end time.Time) []CommitPattern {
+	SortPatterns(patterns)
+
+	var merges []CommitPattern
+	for i := range patterns {
+		if patterns[i].CommitType != "feature" || patterns[i].Branch != "" {
+			continue
+		}
+
+		first := patterns[i]
+		name := topics.next(first.Description)
+		size := rand.Intn(maxTopicCommits-1) + 2
+
+		last := first
+		count := 0
+		for j := i; j < len(patterns) && count < size; j++ {
+			if patterns[j].CommitType != "feature" || patterns[j].Branch != "" {
+				continue
+			}
+			if patterns[j].Timestamp.Sub(first.Timestamp) > maxTopicSpan {
+				break
+			}
+			patterns[j].Branch = name
+			last = patterns[j]
+			count++
+		}
+
+		mergeTime, ok := followUpTime(last.Timestamp, end, time.Duration(30+rand.Intn(210))*time.Minute)
+		if !ok {
+			continue
+		}
+		merge := CommitPattern{
+			Timestamp:   mergeTime,
+			CommitType:  "merge",
+			ChangeType:  ChangeMerge,
+			Description: fmt.Sprintf("Merge branch '%s'", name),
+			Persona:     first.Persona,
+			SprintPhase: first.SprintPhase,
+			MergeBranch: name,
+		}
+		if rand.Float64() < g.workflow.SquashRatio {
+			merge.ChangeType = ChangeSquashMerge
+			merge.Description = first.Description
+			merge.Squash = true
+		}
+		merges = append(merges, merge)
+	}
+
+	patterns = append(patterns, merges...)
+	SortPatterns(patterns)
+	return patterns
+}
+
+// topicNamer derives unique branch names from commit descriptions
+type topicNamer struct {
+	prefix string
+	used   map[string]int
+}
+
+func newTopicNamer(prefix string) *topicNamer {
+	return &topicNamer{prefix: prefix, used: make(map[string]int)}
+}
+
+// next turns a description such as "Add ui to frontend" into
+// "feature/add-ui-to-frontend", numbering repeats
+func (t *topicNamer) next(description string) string {
+	words := strings.Fields(nonSlugChars.ReplaceAllString(strings.ToLower(description), " "))
+	if len(words) > 4 {
+		words = words[:4]
+	}
+	slug := strings.Join(words, "-")
+	if slug == "" {
+		slug = "topic"
+	}
+
+	t.used[slug]++
+	if n := t.used[slug]; n > 1 {
+		slug = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return t.prefix + slug
+}
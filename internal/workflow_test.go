@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssignTopicBranchesMergeTime(t *testing.T) {
+	first := time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC)
+	last := first.Add(time.Hour)
+	tests := []struct {
+		name         string
+		end          time.Time
+		earliest     time.Time // first time the merge may have
+		latest       time.Time // last time the merge may have
+		wantUnmerged bool
+	}{
+		{"plenty of room", last.Add(24 * time.Hour), last.Add(30 * time.Minute), last.Add(240 * time.Minute), false},
+		{"squeezed before the end", last.Add(10 * time.Minute), last.Add(time.Second), last.Add(10 * time.Minute), false},
+		{"no room left", last, time.Time{}, time.Time{}, true},
+	}
+
+	g := NewCommitPatternGenerator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []CommitPattern{
+				{Timestamp: first, CommitType: "feature", Description: "Add search"},
+				{Timestamp: last, CommitType: "feature", Description: "Add search filters"},
+			}
+			patterns = g.assignTopicBranches(patterns, newTopicNamer(DefaultBranchPrefix), tt.end)
+
+			var merges []CommitPattern
+			for _, pattern := range patterns {
+				if pattern.Branch == "" && pattern.CommitType == "feature" {
+					t.Errorf("commit %q is not on a topic branch", pattern.Description)
+				}
+				if pattern.MergeBranch != "" {
+					merges = append(merges, pattern)
+				}
+			}
+
+			if tt.wantUnmerged {
+				if len(merges) != 0 {
+					t.Errorf("got merge at %s, want none", merges[0].Timestamp)
+				}
+				return
+			}
+			if len(merges) != 1 {
+				t.Fatalf("got %d merges, want 1", len(merges))
+			}
+			if when := merges[0].Timestamp; when.Before(tt.earliest) || when.After(tt.latest) {
+				t.Errorf("merge at %s, want between %s and %s", when, tt.earliest, tt.latest)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package internal
+
+import "golang.org/x/sys/unix"
+
+// checkWritable asks the kernel whether the current user may write to path,
+// which accounts for ownership, groups and read-only mounts
+func checkWritable(path string) error {
+	return unix.Access(path, unix.W_OK)
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// checkWritable checks the read-only attribute of path, which is all Windows
+// reports without trying to write
+func checkWritable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0200 == 0 {
+		return errors.New("read-only")
+	}
+	return nil
+}